@@ -0,0 +1,23 @@
+package apiserver
+
+import (
+	contextV2 "context"
+	"net/http"
+	"tracedemo/logger"
+)
+
+// 管理接口单独监听, 不经过 :8080 的 NodePort 暴露, 默认只监听本机;
+// 在 k8s 中通过 kubectl port-forward 访问, 例如 kubectl port-forward deploy/trace 8081
+func StartAdminServer(addr string) {
+	mux := http.NewServeMux()
+	//运行时修改日志级别
+	mux.Handle("/admin/log/level", logger.LevelHandler())
+	//JAEGER_REPORTER_MEMORY_SPANS 大于 0 时可用, 包含所有租户的 span, 浏览器访问 /admin/traces?format=html
+	mux.Handle("/admin/traces", logger.TraceHandler())
+
+	logger.Info(contextV2.Background(), "[adminServer]开始监听%s,", addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		logger.Error(contextV2.Background(), "[adminServer]开始监听%s 错误%v,", addr, err)
+	}
+}
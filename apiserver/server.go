@@ -6,58 +6,111 @@ import (
 	"runtime/debug"
 	"tracedemo/apiserver/userinfo"
 	"tracedemo/logger"
+	"tracedemo/tenant"
 
+	"github.com/kataras/golog"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap/zapcore"
 )
 
-func StartApiServerr() {
+// tenants 为租户解析规则, 见 tenant.Config
+func StartApiServerr(tenants *tenant.Chain) {
 	addr := ":8080"
 
 	app := iris.New()
+	logger.SkipCallerPackages("github.com/kataras/golog")
+	app.Logger().Handle(irisLog)
 	app.Use(openTracing())
-	app.Use(withSiteCode())
 	app.Use(withRecover())
 
 	app.Get("/", func(c context.Context) {
 		c.WriteString("pong")
 	})
 
-	initIris(app)
+	initIris(app, tenants)
 	logger.Info(contextV2.Background(),  "[apiServer]开始监听%s,", addr)
 
-	err := app.Run(iris.Addr(addr), iris.WithoutInterruptHandler)
+	//启动信息直接写 stdout, 不经过 Logger, 关闭后由上面的日志代替
+	err := app.Run(iris.Addr(addr), iris.WithoutInterruptHandler, iris.WithoutStartupLog)
 	if err != nil {
 		logger.Error(contextV2.Background(), "[apiServer]开始监听%s 错误%v,", addr,err)
 	}
 }
 
-func initIris(app *iris.Application) {
+// 只有业务接口需要租户, /metrics 和 / 不解析, 严格模式下 Prometheus 和健康检查不需要带 SiteCode
+func initIris(app *iris.Application, tenants *tenant.Chain) {
    api:= userinfo.ApiServer{}
-	userGroup := app.Party("/user")
+	userGroup := app.Party("/user", withTenant(tenants))
 	{
 		userGroup.Get("/test",api.TestUserInfo)
 		userGroup.Get("/rpc",api.TestRpc)
 	}
+
+	//日志级别和 trace 查询见 StartAdminServer
+
+	//grpc_prometheus 和 jaeger tracer 的指标
+	app.Get("/metrics", iris.FromStd(promhttp.Handler()))
+}
+
+// iris 内部日志转到 logger
+func irisLog(l *golog.Log) bool {
+	level := zapcore.InfoLevel
+	switch l.Level {
+	case golog.FatalLevel, golog.ErrorLevel:
+		level = zapcore.ErrorLevel
+	case golog.WarnLevel:
+		level = zapcore.WarnLevel
+	case golog.DebugLevel:
+		level = zapcore.DebugLevel
+	}
+	logger.ComponentLog("iris", level, l.Message)
+	return true
 }
 
 func openTracing() context.Handler {
 	return func(c iris.Context) {
-		span := opentracing.GlobalTracer().StartSpan("apiServer")
+		tracer := opentracing.GlobalTracer()
+		header := c.Request().Header
+
+		opts := []opentracing.StartSpanOption{ext.SpanKindRPCServer}
+		//jaeger-debug-id 由 jaeger 的 Extract 处理, 生成带 debug 标记的 trace
+		if spanContext, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err == nil {
+			opts = append(opts, opentracing.ChildOf(spanContext))
+		}
+		if logger.ForceSampling(header.Get) {
+			opts = append(opts, logger.ForceSamplingOption())
+		}
+
+		span := tracer.StartSpan("apiServer", opts...)
+		defer span.Finish()
 		c.ResetRequest(c.Request().WithContext(opentracing.ContextWithSpan(c.Request().Context(), span)))
 		logger.Info(c.Request().Context(), "Api请求地址%v", c.Request().URL)
 		c.Next()
 	}
 }
 
-func withSiteCode() context.Handler {
+// 按 chain 解析租户并设置到上下文, 严格模式下解析失败返回 400
+func withTenant(chain *tenant.Chain) context.Handler {
 	return func(c iris.Context) {
-		siteCode := c.GetHeader("SiteCode")
-		if len(siteCode) < 1 {
-			siteCode = "001"
+		siteCode, err := chain.Resolve(&tenant.Request{
+			Header:  c.GetHeader,
+			Baggage: tenant.Baggage(c.Request().Context()),
+			Host:    c.Request().Host,
+			Path:    c.Path(),
+		})
+		if err != nil {
+			logger.Warn(c.Request().Context(), "解析租户错误, 请求地址:%v, %v", c.Request().URL, err)
+			c.StatusCode(iris.StatusBadRequest)
+			c.WriteString(err.Error())
+			c.StopExecution()
+			return
 		}
-		ctx := contextV2.WithValue(c.Request().Context(), "SiteCode", siteCode)
+		ctx := tenant.WithTenant(c.Request().Context(), siteCode)
+		logger.ForceSamplingSiteCode(ctx, siteCode)
 		c.ResetRequest(c.Request().WithContext(ctx))
 
 		c.Next()
@@ -33,6 +33,11 @@ func (t *ApiServer) TestRpc(ctx iris.Context) {
 			middleware.ClientSiteCode(),
 			middleware.ClientTimeLog(),
 			)),
+		grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(
+			middleware.ClientStreamTracing(opentracing.GlobalTracer()),
+			middleware.ClientStreamSiteCode(),
+			middleware.ClientStreamTimeLog(),
+		)),
 	}
 
 	conn, err := grpc.Dial(addr, opts...)
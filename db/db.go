@@ -7,8 +7,9 @@ import (
 	"net/url"
 	"reflect"
 	"regexp"
-	"strings"
 	"tracedemo/logger"
+	"tracedemo/redact"
+	"tracedemo/tenant"
 	"unicode"
 
 	"github.com/jinzhu/gorm"
@@ -42,6 +43,8 @@ const (
 
 func init() {
 	connMap = make(map[string]*gorm.DB)
+	//SQL 日志的 callPath 指向调用 gorm 的业务代码, 而不是回调所在的 db.go
+	logger.SkipCallerPackages("github.com/jinzhu/gorm", "tracedemo/db")
 }
 
 var (
@@ -67,6 +70,9 @@ func InitDb(siteCode string, cfg *Config) (err error) {
 		return errors.Wrap(err, "fail to connect db")
 	}
 
+	//gorm 内部的日志(例如 AddError)默认直接写 stdout, 改为经过 logger 输出并脱敏
+	conn.SetLogger(logger.GormLogger())
+
 	//新增gorm插件
 	if cfg.Debug == true {
 		registerCallbacks(conn)
@@ -96,8 +102,8 @@ func GetMaster(ctx context.Context) *gorm.DB {
 	connLock.RLock()
 	defer connLock.RUnlock()
 
-	siteCode := fmt.Sprintf("%v", ctx.Value("SiteCode"))
-	if strings.Contains(siteCode, "nil") {
+	siteCode, ok := tenant.TenantFrom(ctx)
+	if !ok {
 		panic(errors.New("当前上下文没有找到DB"))
 	}
 
@@ -118,7 +124,7 @@ func mysqlHeart(conn *gorm.DB) {
 		if conn != nil {
 			err := conn.DB().Ping()
 			if err != nil {
-				fmt.Println(fmt.Sprintf("mysqlHeart has err:%v", err))
+				logger.Error(context.Background(), err, "mysqlHeart")
 			}
 		}
 
@@ -208,14 +214,14 @@ func newAfterCallback() func(*gorm.Scope) {
 			duration = (time.Now().UnixNano() - t) / 1e6
 		}
 
-		logger.Debug(ctx, "[gorm] [%vms] [RowsReturned(%v)] %v  ", duration, scope.DB().RowsAffected, gormSQL(scope.SQL, scope.SQLVars))
+		logger.Debug(ctx, "[gorm] [%vms] [RowsReturned(%v)] %v  ", duration, scope.DB().RowsAffected, redact.SQL(gormSQL(scope.SQL, scope.SQLVars)))
 
 		for _, err := range scope.DB().GetErrors() {
 			if gorm.IsRecordNotFoundError(err) || err == errors.New("sql: no rows in result set") {
 				continue
 			}
 			//打印错误日志
-			logger.Error(ctx, "%v", err.Error())
+			logger.Error(ctx, err)
 		}
 		//span.LogFields(traceLog.String("sql", scope.SQL))
 	}
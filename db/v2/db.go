@@ -2,13 +2,11 @@ package db
 
 import (
 	"context"
-	"github.com/opentracing/opentracing-go"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
-	gormLogger "gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
-	"strings"
 	"tracedemo/logger"
+	"tracedemo/tenant"
 
 	"fmt"
 	"net/url"
@@ -40,6 +38,8 @@ const (
 
 func init() {
 	connMap = make(map[string]*gorm.DB)
+	//SQL 日志的 callPath 指向调用 gorm 的业务代码, 而不是回调所在的 db.go, 按包设置的级别也按业务代码的包匹配
+	logger.SkipCallerPackages("gorm.io/gorm", "tracedemo/db/v2")
 }
 
 var (
@@ -65,8 +65,8 @@ func InitDb(siteCode string, cfg *Config) (err error) {
 		},
 		SkipDefaultTransaction: true,
 	}
-	config.Logger = newLogger()
-	config.Logger.LogMode(gormLogger.Info)
+	//gorm 的日志和 SQL 经过 logger 输出并脱敏
+	config.Logger = logger.GormV2Logger()
 
 	conn, err := gorm.Open(mysql.Open(dsn), config)
 	if err != nil {
@@ -100,8 +100,8 @@ func GetMaster(ctx context.Context) *gorm.DB {
 	connLock.RLock()
 	defer connLock.RUnlock()
 
-	siteCode := fmt.Sprintf("%v", ctx.Value("SiteCode"))
-	if strings.Contains(siteCode, "nil") {
+	siteCode, ok := tenant.TenantFrom(ctx)
+	if !ok {
 		panic(errors.New("当前上下文没有找到DB"))
 	}
 
@@ -116,45 +116,3 @@ func GetMaster(ctx context.Context) *gorm.DB {
 
 	return db.WithContext(ctx)
 }
-
-type sqlLogger struct {
-	silent bool
-}
-
-func newLogger() *sqlLogger {
-	return &sqlLogger{}
-}
-
-func (s *sqlLogger) LogMode(level gormLogger.LogLevel) gormLogger.Interface {
-	switch level {
-	case gormLogger.Silent:
-		s.silent = true
-	}
-	return s
-}
-
-func (s *sqlLogger) Info(ctx context.Context, template string, args ...interface{}) {
-	if !s.silent {
-		logger.Info(ctx, template, args)
-	}
-}
-
-func (s *sqlLogger) Warn(ctx context.Context, template string, args ...interface{}) {
-	if !s.silent {
-		logger.Warn(ctx, template, args)
-	}
-}
-func (s *sqlLogger) Error(ctx context.Context, template string, args ...interface{}) {
-	if !s.silent {
-		logger.Error(ctx, template, args)
-	}
-}
-func (s *sqlLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
-	if s.silent {
-		return
-	}
-	sql, rows := fc()
-	elapsed := time.Since(begin)
-	logger.Debug(ctx, "[gorm] [%vms] [RowsReturned(%v)] %v  ", elapsed, rows, sql)
-
-}
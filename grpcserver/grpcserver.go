@@ -2,35 +2,44 @@ package grpcserver
 
 import (
 	"context"
-	"log"
 	"net"
 	"tracedemo/logger"
 	"tracedemo/middleware"
 	pb "tracedemo/protos"
+	"tracedemo/tenant"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/opentracing/opentracing-go"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/reflection"
 )
 
-func StartGrpcServer() {
+// tenants 为租户解析规则, 见 tenant.Config
+func StartGrpcServer(tenants *tenant.Chain) {
 	addr := ":9090"
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatalf("[activeServer] field to listen %v,", err)
+		grpclog.Fatalf("[activeServer] field to listen %v,", err)
 	}
 
 	s := grpc.NewServer(grpc.UnaryInterceptor(
 		grpc_middleware.ChainUnaryServer(
 			grpc_prometheus.UnaryServerInterceptor,
 			middleware.ServerTracing(opentracing.GlobalTracer()), //jaeger
-			middleware.ServerSiteCode(),                          //jaeger
+			middleware.ServerTenant(tenants),                     //解析租户, 写入 baggage
 			middleware.ServerTimeLog(),
 			//grpc_recovery.UnaryServerInterceptor(),               //panic recover
 		),
+	), grpc.StreamInterceptor(
+		grpc_middleware.ChainStreamServer(
+			grpc_prometheus.StreamServerInterceptor,
+			middleware.ServerStreamTracing(opentracing.GlobalTracer()),
+			middleware.ServerStreamTenant(tenants),
+			middleware.ServerStreamTimeLog(),
+		),
 	))
 
 	// 注册服务
@@ -0,0 +1,236 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"tracedemo/redact"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// 第三方库的日志统一输出为本包的格式, 带 component 字段区分来源
+// 适配器的方法直接调用 jsonStdOut, callPath 为调用适配器的库代码
+
+// 在第三方库的日志回调中调用, 输出一条该库的日志, callPath 跳过回调函数本身
+func ComponentLog(component string, level zapcore.Level, msg string) {
+	jsonStdOut(context.Background(), 1, level, strings.TrimRight(msg, "\n"), []zap.Field{zap.String("component", component)}, nil)
+}
+
+// 实现 jaeger.Logger 和 log.DebugLogger, 用于 config.Logger
+type jaegerLogger struct{}
+
+func JaegerLogger() jaegerLogger {
+	return jaegerLogger{}
+}
+
+func (jaegerLogger) Error(msg string) {
+	jsonStdOut(context.Background(), 0, zap.ErrorLevel, strings.TrimRight(msg, "\n"), jaegerFields, nil)
+}
+
+func (jaegerLogger) Infof(msg string, args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.InfoLevel, strings.TrimRight(fmt.Sprintf(msg, args...), "\n"), jaegerFields, nil)
+}
+
+func (jaegerLogger) Debugf(msg string, args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.DebugLevel, strings.TrimRight(fmt.Sprintf(msg, args...), "\n"), jaegerFields, nil)
+}
+
+var (
+	jaegerFields = []zap.Field{zap.String("component", "jaeger")}
+	grpcFields   = []zap.Field{zap.String("component", "grpc")}
+	gormFields   = []zap.Field{zap.String("component", "gorm")}
+)
+
+// grpc 日志的级别和 verbose 与 grpc 默认 logger 使用相同的环境变量
+const (
+	grpcPackage      = "google.golang.org/grpc"
+	envGRPCSeverity  = "GRPC_GO_LOG_SEVERITY_LEVEL"  //info、warning、error, 未设置时为 warn
+	envGRPCVerbosity = "GRPC_GO_LOG_VERBOSITY_LEVEL" //V(l) 中 l 不大于它时输出, 默认 0
+)
+
+// 实现 grpclog.LoggerV2, 通过 grpclog.SetLoggerV2 设置;
+// grpc 的 info 日志很多, 这里降为 debug, 并且不跟随全局级别:
+// 默认为 google.golang.org/grpc 设置 warn 级别, 可通过 LevelOverrides 或 GRPC_GO_LOG_SEVERITY_LEVEL 修改
+type grpcLogger struct {
+	verbosity int //GRPC_GO_LOG_VERBOSITY_LEVEL
+}
+
+func GRPCLogger() grpclog.LoggerV2 {
+	//跳过 grpclog 的包装函数, callPath 指向 grpc 中实际打日志的位置
+	SkipCallerPackages("google.golang.org/grpc/grpclog", "google.golang.org/grpc/internal/grpclog", "google.golang.org/grpc/internal/channelz")
+
+	//已经通过 LevelOverrides 设置时不覆盖
+	if _, ok := LevelOverrides()[grpcPackage]; !ok {
+		level := "warn"
+		switch strings.ToLower(os.Getenv(envGRPCSeverity)) {
+		case "info":
+			level = "debug"
+		case "error":
+			level = "error"
+		}
+		SetLevelOverride(grpcPackage, level)
+	}
+
+	l := grpcLogger{}
+	if e := os.Getenv(envGRPCVerbosity); e != "" {
+		if v, err := strconv.Atoi(e); err == nil {
+			l.verbosity = v
+		}
+	}
+	return l
+}
+
+func (grpcLogger) Info(args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.DebugLevel, fmt.Sprint(args...), grpcFields, nil)
+}
+
+func (grpcLogger) Infoln(args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.DebugLevel, sprintln(args), grpcFields, nil)
+}
+
+func (grpcLogger) Infof(format string, args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.DebugLevel, fmt.Sprintf(format, args...), grpcFields, nil)
+}
+
+func (grpcLogger) Warning(args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.WarnLevel, fmt.Sprint(args...), grpcFields, nil)
+}
+
+func (grpcLogger) Warningln(args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.WarnLevel, sprintln(args), grpcFields, nil)
+}
+
+func (grpcLogger) Warningf(format string, args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.WarnLevel, fmt.Sprintf(format, args...), grpcFields, nil)
+}
+
+func (grpcLogger) Error(args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.ErrorLevel, fmt.Sprint(args...), grpcFields, nil)
+}
+
+func (grpcLogger) Errorln(args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.ErrorLevel, sprintln(args), grpcFields, nil)
+}
+
+func (grpcLogger) Errorf(format string, args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.ErrorLevel, fmt.Sprintf(format, args...), grpcFields, nil)
+}
+
+// LoggerV2 要求 Fatal 之后退出进程, zap 写入 fatal 级别的日志后会同步输出并退出
+func (grpcLogger) Fatal(args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.FatalLevel, fmt.Sprint(args...), grpcFields, nil)
+}
+
+func (grpcLogger) Fatalln(args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.FatalLevel, sprintln(args), grpcFields, nil)
+}
+
+func (grpcLogger) Fatalf(format string, args ...interface{}) {
+	jsonStdOut(context.Background(), 0, zap.FatalLevel, fmt.Sprintf(format, args...), grpcFields, nil)
+}
+
+// verbose 日志由 GRPC_GO_LOG_VERBOSITY_LEVEL 控制, 与全局级别无关
+func (g grpcLogger) V(l int) bool {
+	return l <= g.verbosity
+}
+
+// 实现 jinzhu/gorm 的 logger(只有 Print 方法), 通过 gorm.DB.SetLogger 设置,
+// 代替默认直接写 stdout 的 logger, AddError 等 gorm 内部日志也经过脱敏;
+// v[0] 为类型 sql、log、error, v[1] 为 gorm 记录的业务代码位置, 输出为 source 字段
+type gormLogger struct{}
+
+func GormLogger() gormLogger {
+	return gormLogger{}
+}
+
+func (gormLogger) Print(v ...interface{}) {
+	if len(v) < 2 {
+		jsonStdOut(context.Background(), 0, zap.InfoLevel, fmt.Sprint(v...), gormFields, nil)
+		return
+	}
+
+	fields := append([]zap.Field{zap.String("source", fmt.Sprint(v[1]))}, gormFields...)
+	switch v[0] {
+	case "sql":
+		//sql, 位置, 耗时, 语句, 参数, 影响行数; 参数不输出, 带参数的 SQL 由 db 包的回调记录
+		if len(v) >= 6 {
+			jsonStdOut(context.Background(), 0, zap.DebugLevel, fmt.Sprintf("[gorm] [%v] [RowsAffected(%v)] %v", v[2], v[5], redact.SQL(fmt.Sprint(v[3]))), fields, nil)
+			return
+		}
+	case "error":
+		var err error
+		for _, e := range v[2:] {
+			if e, ok := e.(error); ok {
+				err = e
+			}
+		}
+		jsonStdOut(context.Background(), 0, zap.ErrorLevel, fmt.Sprint(v[2:]...), fields, err)
+		return
+	}
+	jsonStdOut(context.Background(), 0, zap.InfoLevel, fmt.Sprint(v[2:]...), fields, nil)
+}
+
+// 实现 gorm.io/gorm 的 logger.Interface, 用于 gorm.Config.Logger;
+// 级别为 0 时全部交给本包的级别控制, SQL 为 debug 级别
+type gormV2Logger struct {
+	level gormlogger.LogLevel
+}
+
+func GormV2Logger() gormlogger.Interface {
+	return gormV2Logger{}
+}
+
+func (l gormV2Logger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	return gormV2Logger{level: level}
+}
+
+func (l gormV2Logger) enabled(level gormlogger.LogLevel) bool {
+	return l.level == 0 || l.level >= level
+}
+
+func (l gormV2Logger) Info(ctx context.Context, format string, args ...interface{}) {
+	if l.enabled(gormlogger.Info) {
+		jsonStdOut(ctx, 0, zap.InfoLevel, fmt.Sprintf(format, args...), gormFields, nil)
+	}
+}
+
+func (l gormV2Logger) Warn(ctx context.Context, format string, args ...interface{}) {
+	if l.enabled(gormlogger.Warn) {
+		jsonStdOut(ctx, 0, zap.WarnLevel, fmt.Sprintf(format, args...), gormFields, nil)
+	}
+}
+
+func (l gormV2Logger) Error(ctx context.Context, format string, args ...interface{}) {
+	if l.enabled(gormlogger.Error) {
+		jsonStdOut(ctx, 0, zap.ErrorLevel, fmt.Sprintf(format, args...), gormFields, findError(nil, args))
+	}
+}
+
+// 每条 SQL 执行后调用, 出错时(记录不存在除外)输出 error 级别
+func (l gormV2Logger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.level == gormlogger.Silent {
+		return
+	}
+	sql, rows := fc()
+	msg := fmt.Sprintf("[gorm] [%vms] [RowsReturned(%v)] %v", time.Since(begin).Milliseconds(), rows, redact.SQL(sql))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.enabled(gormlogger.Error) {
+		jsonStdOut(ctx, 0, zap.ErrorLevel, msg, gormFields, err)
+		return
+	}
+	if l.enabled(gormlogger.Info) {
+		jsonStdOut(ctx, 0, zap.DebugLevel, msg, gormFields, nil)
+	}
+}
+
+func sprintln(args []interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
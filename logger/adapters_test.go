@@ -0,0 +1,180 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/grpclog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// 日志写入临时文件, 返回的函数读取已经输出的日志; 结束时恢复默认配置和级别
+func captureLogs(t *testing.T) func() []map[string]interface{} {
+	filename := filepath.Join(t.TempDir(), "test.log")
+	if err := Init(Config{Sinks: []SinkConfig{{Type: SinkFile, File: FileConfig{Filename: filename}}}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for prefix := range LevelOverrides() {
+			RemoveLevelOverride(prefix)
+		}
+		SetLevel("debug")
+		Init(Config{})
+	})
+
+	return func() []map[string]interface{} {
+		Sync()
+		f, err := os.Open(filename)
+		if os.IsNotExist(err) {
+			//没有输出过日志时文件还没有创建
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		var records []map[string]interface{}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("invalid log line %q: %v", scanner.Text(), err)
+			}
+			records = append(records, record)
+		}
+		return records
+	}
+}
+
+func contents(records []map[string]interface{}) []string {
+	var ret []string
+	for _, r := range records {
+		message, _ := r["message"].(map[string]interface{})
+		content, _ := message["content"].(string)
+		ret = append(ret, content)
+	}
+	return ret
+}
+
+// grpc 日志默认为 warn, 不跟随全局的 debug 级别
+func TestGRPCLoggerLevel(t *testing.T) {
+	cases := []struct {
+		name      string
+		severity  string //GRPC_GO_LOG_SEVERITY_LEVEL
+		verbosity string //GRPC_GO_LOG_VERBOSITY_LEVEL
+		override  string //LevelOverrides 中 google.golang.org/grpc 的级别
+		want      []string
+		wantV2    bool
+	}{
+		{"default", "", "", "", []string{"warning", "error"}, false},
+		{"severity info", "info", "", "", []string{"info", "warning", "error"}, false},
+		{"severity error", "error", "", "", []string{"error"}, false},
+		{"override wins", "error", "", "debug", []string{"info", "warning", "error"}, false},
+		{"verbosity", "", "2", "", []string{"warning", "error"}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			read := captureLogs(t)
+			t.Setenv(envGRPCSeverity, c.severity)
+			t.Setenv(envGRPCVerbosity, c.verbosity)
+			if c.override != "" {
+				SetLevelOverride(grpcPackage, c.override)
+			}
+
+			l := GRPCLogger()
+			grpclog.SetLoggerV2(l)
+			t.Cleanup(func() { grpclog.SetLoggerV2(grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)) })
+			grpclog.Info("info")
+			grpclog.Warning("warning")
+			grpclog.Error("error")
+
+			if got := contents(read()); !equalStrings(got, c.want) {
+				t.Errorf("logs = %v, want %v", got, c.want)
+			}
+			if l.V(0) != true || l.V(2) != c.wantV2 {
+				t.Errorf("V(0) = %v, V(2) = %v, want V(2) %v", l.V(0), l.V(2), c.wantV2)
+			}
+		})
+	}
+}
+
+// 只生成 SQL 不连接数据库的 gorm 方言, 配合 DryRun 使用
+type dryRunDialector struct{}
+
+func (dryRunDialector) Name() string { return "dryrun" }
+
+func (dryRunDialector) Initialize(db *gorm.DB) error {
+	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{})
+	return nil
+}
+
+func (dryRunDialector) Migrator(db *gorm.DB) gorm.Migrator { return nil }
+
+func (dryRunDialector) DataTypeOf(*schema.Field) string { return "" }
+
+func (dryRunDialector) DefaultValueOf(*schema.Field) clause.Expression { return nil }
+
+func (dryRunDialector) BindVarTo(writer clause.Writer, stmt *gorm.Statement, v interface{}) {
+	writer.WriteByte('?')
+}
+
+func (dryRunDialector) QuoteTo(writer clause.Writer, str string) {
+	writer.WriteString("`" + str + "`")
+}
+
+func (dryRunDialector) Explain(sql string, vars ...interface{}) string { return sql }
+
+// SQL 日志由 gorm 的回调输出, 按包设置的级别匹配调用 gorm 的业务代码, 与 db/v2 的 init 一样跳过 gorm 的包
+func TestGormV2LoggerLevelOverride(t *testing.T) {
+	SkipCallerPackages("gorm.io/gorm")
+	db, err := gorm.Open(dryRunDialector{}, &gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: GormV2Logger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func() {
+		var rows []map[string]interface{}
+		db.Table("users").Where("id = ?", 1).Find(&rows)
+	}
+
+	cases := []struct {
+		name     string
+		override string //本包的级别, 为空时使用全局的 debug
+		want     int
+	}{
+		{"global debug", "", 1},
+		{"calling package warn", "warn", 0},
+		{"calling package debug", "debug", 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			read := captureLogs(t)
+			if c.override != "" {
+				SetLevelOverride("tracedemo/logger", c.override)
+			}
+			query()
+
+			var sql []string
+			for _, r := range read() {
+				message, _ := r["message"].(map[string]interface{})
+				if content, _ := message["content"].(string); strings.Contains(content, "SELECT") {
+					sql = append(sql, content)
+					if callPath, _ := message["callPath"].(string); !strings.HasPrefix(callPath, "logger/adapters_test.go") {
+						t.Errorf("callPath = %q, want the calling code", callPath)
+					}
+				}
+			}
+			if len(sql) != c.want {
+				t.Errorf("sql logs = %v, want %d", sql, c.want)
+			}
+		})
+	}
+}
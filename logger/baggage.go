@@ -0,0 +1,46 @@
+package logger
+
+import (
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"tracedemo/tenant"
+)
+
+// 租户由 tenant.WithTenant 设置为 baggage, 随 span context 传到所有下游;
+// 这里把父 span 的租户 baggage 作为 tag 加到每个新 span 上, Jaeger 中可以按 siteCode 过滤
+type tenantTracer struct {
+	opentracing.Tracer
+}
+
+func (t tenantTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var o opentracing.StartSpanOptions
+	for _, opt := range opts {
+		opt.Apply(&o)
+	}
+
+	if _, ok := o.Tags[tenant.TagKey]; !ok {
+		for _, ref := range o.References {
+			if siteCode := baggageItem(ref.ReferencedContext, tenant.BaggageKey); siteCode != "" {
+				opts = append(opts, opentracing.Tag{Key: tenant.TagKey, Value: siteCode})
+				break
+			}
+		}
+	}
+	return t.Tracer.StartSpan(operationName, opts...)
+}
+
+// OpenTelemetry bridge 把 baggage 的 key 转为 http.CanonicalHeaderKey 的形式, 这里不区分大小写
+func baggageItem(sc opentracing.SpanContext, key string) (value string) {
+	if sc == nil {
+		return ""
+	}
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		if strings.EqualFold(k, key) {
+			value = v
+			return false
+		}
+		return true
+	})
+	return value
+}
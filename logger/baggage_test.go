@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"tracedemo/tenant"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+func newLocalTracer(t *testing.T, backend string, propagators ...string) opentracing.Tracer {
+	c := DefaultTracerConfig("baggage-"+backend, "127.0.0.1:6831")
+	c.Backend = backend
+	c.Sampler.Type = "const"
+	c.Sampler.Param = 1
+	c.Reporter.FileOnly = true
+	c.Reporter.MemorySpans = 10
+	c.Propagators = propagators
+
+	tracer, closer, err := NewTracer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closer.Close() })
+	return tracer
+}
+
+// 一端通过 tenant.WithTenant 设置租户并注入, 另一端提取后 tenant.TenantFrom 能读到
+func TestTenantBaggageAcrossBackends(t *testing.T) {
+	propagators := []string{PropagatorTraceContext, PropagatorBaggage}
+	jaegerTracer := newLocalTracer(t, TracerBackendJaeger, propagators...)
+	otelTracer := newLocalTracer(t, TracerBackendOTLP, propagators...)
+
+	cases := []struct {
+		name     string
+		from, to opentracing.Tracer
+	}{
+		{"otel to jaeger", otelTracer, jaegerTracer},
+		{"jaeger to otel", jaegerTracer, otelTracer},
+		{"otel to otel", otelTracer, otelTracer},
+		{"jaeger to jaeger", jaegerTracer, jaegerTracer},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := c.from.StartSpan("client")
+			defer client.Finish()
+			tenant.WithTenant(opentracing.ContextWithSpan(context.Background(), client), "007")
+
+			headers := http.Header{}
+			if err := c.from.Inject(client.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(headers)); err != nil {
+				t.Fatal(err)
+			}
+			sc, err := c.to.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(headers))
+			if err != nil {
+				t.Fatalf("extract %v: %v", headers, err)
+			}
+			server := c.to.StartSpan("server", opentracing.ChildOf(sc))
+			defer server.Finish()
+
+			ctx := opentracing.ContextWithSpan(context.Background(), server)
+			if siteCode, ok := tenant.TenantFrom(ctx); !ok || siteCode != "007" {
+				t.Errorf("TenantFrom = %q, %v, headers %v", siteCode, ok, headers)
+			}
+			if siteCode := tenant.Baggage(ctx)(tenant.BaggageKey); siteCode != "007" {
+				t.Errorf("Baggage = %q", siteCode)
+			}
+		})
+	}
+}
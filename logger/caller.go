@@ -0,0 +1,133 @@
+package logger
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+type caller struct {
+	pc       uintptr
+	file     string
+	line     int
+	function string
+	ok       bool
+}
+
+// 模块根目录, 由本文件的路径推出, callPath 默认为相对于它的路径
+var moduleRoot = func() string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		return ""
+	}
+	return path.Dir(path.Dir(file)) + "/"
+}()
+
+var (
+	skipPackages []string
+	skipLock     sync.RWMutex
+)
+
+// 报告调用位置时跳过这些包中的栈帧, 用于封装了日志的库, 例如 gorm 的回调;
+// 按包设置的日志级别同时匹配直接调用日志的包和跳过后的业务代码
+func SkipCallerPackages(prefixes ...string) {
+	skipLock.Lock()
+	defer skipLock.Unlock()
+	skipPackages = append(skipPackages, prefixes...)
+}
+
+// skip 为相对于 getCaller 调用方的层数
+func getCaller(skip int) caller {
+	pc, file, line, ok := runtime.Caller(skip + 1)
+	return caller{pc: pc, file: file, line: line, ok: ok}
+}
+
+// 从 skip 层开始向上查找第一个不在 SkipCallerPackages 中的栈帧, 都被跳过时返回 skip 层
+func reportCaller(skip int) caller {
+	var pcs [32]uintptr
+	n := runtime.Callers(skip+2, pcs[:])
+	if n == 0 {
+		return caller{}
+	}
+
+	frames := runtime.CallersFrames(pcs[:n])
+	var first caller
+	for {
+		f, more := frames.Next()
+		c := caller{pc: f.PC, file: f.File, line: f.Line, function: f.Function, ok: true}
+		if !first.ok {
+			first = c
+		}
+		pkg := c.pkg()
+		//到达 goroutine 的起点, 说明整个调用栈都在跳过的包中
+		if pkg == "runtime" {
+			return first
+		}
+		if !skipCaller(pkg) {
+			return c
+		}
+		if !more {
+			return first
+		}
+	}
+}
+
+func skipCaller(pkg string) bool {
+	skipLock.RLock()
+	defer skipLock.RUnlock()
+
+	for _, p := range skipPackages {
+		if pkg == p || strings.HasPrefix(pkg, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// 默认为模块内的相对路径, 例如 service/userInfo.go:20, 模块外的文件为 包路径/文件名
+func (c caller) callPath() string {
+	if !c.ok {
+		return ""
+	}
+	file := c.file
+	if !logConfig.CallerFullPath {
+		if moduleRoot != "" && strings.HasPrefix(file, moduleRoot) {
+			file = file[len(moduleRoot):]
+		} else if pkg := c.pkg(); pkg != "" {
+			file = pkg + "/" + path.Base(file)
+		}
+	}
+	return strings.Replace(fmt.Sprintf("%s:%d", file, c.line), "%2e", ".", -1)
+}
+
+// 函数名, 例如 service.(*UserService).Create
+func (c caller) functionName() string {
+	name := c.function
+	if name == "" {
+		fn := runtime.FuncForPC(c.pc)
+		if fn == nil {
+			return ""
+		}
+		name = fn.Name()
+	}
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// 调用方所在包的导入路径, 例如 tracedemo/db
+func (c caller) pkg() string {
+	name := c.function
+	if name == "" {
+		fn := runtime.FuncForPC(c.pc)
+		if fn == nil {
+			return ""
+		}
+		name = fn.Name()
+	}
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot]
+	}
+	return name
+}
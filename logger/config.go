@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"io"
+	"os"
+)
+
+// 日志配置
+type Config struct {
+	Encoding string //json(默认)、ecs、gelf、logfmt、console
+	SpanLog  bool   //上下文中有 span 时, 同时把日志写到 span 的 log 里, Error 级别会给 span 打上 error=true
+
+	Level            string            //全局级别, 默认 debug, 运行时可通过 SetLevel 或 LevelHandler 修改
+	DebugSampledOnly bool              //debug 日志只在 span 被采样或带 debug 标记时输出, 与 Jaeger 中可查看的 trace 对应
+	LevelOverrides   map[string]string //包或 callPath 前缀 -> 级别, 例如 {"tracedemo/db": "debug"}
+
+	Sinks    []SinkConfig   //输出目标, 为空时输出到 stderr
+	Sampling SamplingConfig //相同日志去重和按级别限流, 默认关闭
+
+	CallerSkip     int  //所有日志的调用位置向上多跳过的层数, 整个项目通过一层封装调用日志时使用
+	CallerFunction bool //输出调用日志的函数名
+	CallerFullPath bool //callPath 输出完整的文件路径, 默认为模块内的相对路径
+
+	//服务标识, 每条日志都会带上, Hostname 为空时取本机 hostname
+	ServiceName string
+	Hostname    string
+	Version     string
+	Env         string
+}
+
+var (
+	logConfig  Config
+	logClosers []io.Closer
+)
+
+// 按配置初始化日志, 在程序启动时调用一次
+func Init(c Config) error {
+	if c.Hostname == "" {
+		c.Hostname, _ = os.Hostname()
+	}
+
+	zl, l, closers, err := buildLogger(c)
+	if err != nil {
+		return err
+	}
+	sp, err := newSampler(c.Sampling)
+	if err != nil {
+		closeAll(closers)
+		return err
+	}
+
+	if c.Level != "" {
+		if err := SetLevel(c.Level); err != nil {
+			sp.Close()
+			closeAll(closers)
+			return err
+		}
+	}
+	for prefix, level := range c.LevelOverrides {
+		if err := SetLevelOverride(prefix, level); err != nil {
+			sp.Close()
+			closeAll(closers)
+			return err
+		}
+	}
+
+	old, oldClosers, oldSampler := zapLogger, logClosers, logSampler
+	zapLogger, logLayout, logConfig, logClosers, logSampler = zl, l, c, closers, sp
+	//旧窗口的汇总写入新的输出
+	oldSampler.Close()
+	if old != nil {
+		old.Sync()
+	}
+	closeAll(oldClosers)
+	return nil
+}
+
+// 刷新缓冲并关闭文件等输出, 在程序退出前调用
+func Sync() error {
+	logSampler.flush()
+	err := zapLogger.Sync()
+	closeAll(logClosers)
+	logClosers = nil
+	return err
+}
@@ -0,0 +1,59 @@
+package logger
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+	"tracedemo/tenant"
+)
+
+// 从上下文中提取一个日志字段, ok 为 false 时不输出
+type ContextExtractor func(ctx context.Context) (value interface{}, ok bool)
+
+type namedExtractor struct {
+	key string
+	fn  ContextExtractor
+}
+
+var (
+	extractors    []namedExtractor
+	extractorLock sync.RWMutex
+)
+
+// 注册上下文字段提取器, 例如 requestId、userId, 同名 key 会覆盖之前的注册
+func RegisterExtractor(key string, fn ContextExtractor) {
+	extractorLock.Lock()
+	defer extractorLock.Unlock()
+
+	for i, e := range extractors {
+		if e.key == key {
+			extractors[i].fn = fn
+			return
+		}
+	}
+	extractors = append(extractors, namedExtractor{key: key, fn: fn})
+}
+
+func contextFields(ctx context.Context) []zap.Field {
+	extractorLock.RLock()
+	defer extractorLock.RUnlock()
+
+	if len(extractors) == 0 {
+		return nil
+	}
+
+	fields := make([]zap.Field, 0, len(extractors))
+	for _, e := range extractors {
+		if v, ok := e.fn(ctx); ok {
+			fields = append(fields, zap.Any(e.key, v))
+		}
+	}
+	return fields
+}
+
+// 当前请求的租户
+func siteCodeFrom(ctx context.Context) string {
+	siteCode, _ := tenant.TenantFrom(ctx)
+	return siteCode
+}
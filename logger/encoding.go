@@ -0,0 +1,256 @@
+package logger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 日志输出格式
+const (
+	EncodingJSON    = "json"    //默认格式, 所有字段嵌套在 message 下
+	EncodingECS     = "ecs"     //Elastic Common Schema
+	EncodingGELF    = "gelf"    //Graylog Extended Log Format 1.1
+	EncodingLogfmt  = "logfmt"  //key=value
+	EncodingConsole = "console" //带颜色的单行文本, 本地开发用
+)
+
+const ecsVersion = "1.6.0"
+
+// 一种输出格式: zap encoder 的配置, 以及如何把一条日志记录转成 zap 的 message 和字段
+type layout struct {
+	encoder       string
+	encoderConfig func() zapcore.EncoderConfig
+	entry         func(r *jsonRecord) (string, []zap.Field)
+	reserved      map[string]bool //固定字段的 key, 同名的上下文和结构化字段会被改名, 见 rename
+}
+
+var layouts = map[string]layout{
+	EncodingJSON: {
+		encoder: "json",
+		encoderConfig: func() zapcore.EncoderConfig {
+			c := zap.NewProductionEncoderConfig()
+			c.LevelKey = ""
+			c.CallerKey = ""
+			c.MessageKey = "logModel"
+			c.TimeKey = ""
+			return c
+		},
+		entry: func(r *jsonRecord) (string, []zap.Field) {
+			return "zap", []zap.Field{zap.Object("message", *r)}
+		},
+		reserved: keySet("traceId", "spanId", "content", "callPath", "function", "logDate", "level", "siteCode", "service", "hostname", "version", "env"),
+	},
+	EncodingECS: {
+		encoder: "json",
+		encoderConfig: func() zapcore.EncoderConfig {
+			return zapcore.EncoderConfig{
+				TimeKey:        "@timestamp",
+				LevelKey:       "log.level",
+				MessageKey:     "message",
+				LineEnding:     zapcore.DefaultLineEnding,
+				EncodeLevel:    zapcore.LowercaseLevelEncoder,
+				EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+				EncodeDuration: zapcore.NanosDurationEncoder,
+			}
+		},
+		entry: func(r *jsonRecord) (string, []zap.Field) {
+			fields := []zap.Field{zap.String("ecs.version", ecsVersion)}
+			fields = appendNonEmpty(fields, "trace.id", r.TraceId)
+			if r.SpanId != 0 {
+				fields = append(fields, zap.String("span.id", fmt.Sprintf("%016x", r.SpanId)))
+			}
+			file, line := splitCallPath(fmt.Sprintf("%v", r.CallPath))
+			fields = appendNonEmpty(fields, "log.origin.file.name", file)
+			if line > 0 {
+				fields = append(fields, zap.Int("log.origin.file.line", line))
+			}
+			fields = appendNonEmpty(fields, "log.origin.function", r.Function)
+			fields = appendNonEmpty(fields, "labels.siteCode", r.SiteCode)
+			fields = appendNonEmpty(fields, "service.name", r.Service)
+			fields = appendNonEmpty(fields, "service.version", r.Version)
+			fields = appendNonEmpty(fields, "service.environment", r.Env)
+			fields = appendNonEmpty(fields, "host.hostname", r.Hostname)
+			fields = append(fields, r.context...)
+			return fmt.Sprintf("%v", r.Content), append(fields, r.fields...)
+		},
+		reserved: keySet("@timestamp", "log.level", "message", "ecs.version", "trace.id", "span.id", "log.origin.file.name", "log.origin.file.line",
+			"log.origin.function", "labels.siteCode", "service.name", "service.version", "service.environment", "host.hostname"),
+	},
+	EncodingGELF: {
+		encoder: "json",
+		encoderConfig: func() zapcore.EncoderConfig {
+			return zapcore.EncoderConfig{
+				TimeKey:        "timestamp",
+				LevelKey:       "level",
+				MessageKey:     "short_message",
+				LineEnding:     zapcore.DefaultLineEnding,
+				EncodeLevel:    gelfLevelEncoder,
+				EncodeTime:     zapcore.EpochTimeEncoder,
+				EncodeDuration: zapcore.NanosDurationEncoder,
+			}
+		},
+		entry: func(r *jsonRecord) (string, []zap.Field) {
+			host := r.Hostname
+			if host == "" {
+				host = "unknown"
+			}
+			fields := []zap.Field{zap.String("version", "1.1"), zap.String("host", host)}
+			fields = appendNonEmpty(fields, "_traceId", r.TraceId)
+			if r.SpanId != 0 {
+				fields = append(fields, zap.Uint64("_spanId", r.SpanId))
+			}
+			fields = appendNonEmpty(fields, "_callPath", fmt.Sprintf("%v", r.CallPath))
+			fields = appendNonEmpty(fields, "_function", r.Function)
+			fields = appendNonEmpty(fields, "_siteCode", r.SiteCode)
+			fields = appendNonEmpty(fields, "_service", r.Service)
+			fields = appendNonEmpty(fields, "_version", r.Version)
+			fields = appendNonEmpty(fields, "_env", r.Env)
+			//GELF 的附加字段必须以 _ 开头
+			for _, f := range r.context {
+				f.Key = "_" + f.Key
+				fields = append(fields, f)
+			}
+			for _, f := range r.fields {
+				f.Key = "_" + f.Key
+				fields = append(fields, f)
+			}
+			return fmt.Sprintf("%v", r.Content), fields
+		},
+		//加上 _ 前缀之前的 key, GELF 不允许 _id
+		reserved: keySet("traceId", "spanId", "callPath", "function", "siteCode", "service", "version", "env", "id"),
+	},
+	EncodingLogfmt: {
+		encoder:       EncodingLogfmt,
+		encoderConfig: textEncoderConfig,
+		entry:         flatEntry,
+		reserved:      flatReserved,
+	},
+	EncodingConsole: {
+		encoder: "console",
+		encoderConfig: func() zapcore.EncoderConfig {
+			c := textEncoderConfig()
+			c.EncodeLevel = zapcore.CapitalColorLevelEncoder
+			c.ConsoleSeparator = " "
+			return c
+		},
+		entry:    flatEntry,
+		reserved: flatReserved,
+	},
+}
+
+func getLayout(encoding string) (layout, error) {
+	if encoding == "" {
+		encoding = EncodingJSON
+	}
+	l, ok := layouts[encoding]
+	if !ok {
+		return layout{}, errors.Errorf("unknown log encoding %q", encoding)
+	}
+	return l, nil
+}
+
+// logfmt 和 console 共用的配置, 时间带本地时区
+func textEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        "time",
+		LevelKey:       "level",
+		MessageKey:     "msg",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     localTimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+	}
+}
+
+var flatReserved = keySet("time", "level", "msg", "traceId", "spanId", "callPath", "function", "siteCode", "service", "version", "env")
+
+// logfmt 和 console 把所有字段平铺输出, 空字段省略
+func flatEntry(r *jsonRecord) (string, []zap.Field) {
+	var fields []zap.Field
+	fields = appendNonEmpty(fields, "traceId", r.TraceId)
+	if r.SpanId != 0 {
+		fields = append(fields, zap.Uint64("spanId", r.SpanId))
+	}
+	fields = appendNonEmpty(fields, "callPath", fmt.Sprintf("%v", r.CallPath))
+	fields = appendNonEmpty(fields, "function", r.Function)
+	fields = appendNonEmpty(fields, "siteCode", r.SiteCode)
+	fields = appendNonEmpty(fields, "service", r.Service)
+	fields = appendNonEmpty(fields, "version", r.Version)
+	fields = appendNonEmpty(fields, "env", r.Env)
+	fields = append(fields, r.context...)
+	return fmt.Sprintf("%v", r.Content), append(fields, r.fields...)
+}
+
+func keySet(keys ...string) map[string]bool {
+	m := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		m[k] = true
+	}
+	return m
+}
+
+// 与固定字段同名的字段加上 fields. 前缀, 否则输出重复的 key, 大多数解析器取最后一个, 会覆盖日志的元数据
+func (l layout) rename(fields []zap.Field) []zap.Field {
+	var ret []zap.Field
+	for i, f := range fields {
+		if !l.reserved[f.Key] {
+			continue
+		}
+		if ret == nil {
+			ret = append([]zap.Field(nil), fields...)
+		}
+		ret[i].Key = "fields." + f.Key
+	}
+	if ret == nil {
+		return fields
+	}
+	return ret
+}
+
+func appendNonEmpty(fields []zap.Field, key, value string) []zap.Field {
+	if value == "" {
+		return fields
+	}
+	return append(fields, zap.String(key, value))
+}
+
+// 把 callPath 拆为文件名和行号, 例如 service/userInfo.go:20
+func splitCallPath(callPath string) (string, int) {
+	i := strings.LastIndexByte(callPath, ':')
+	if i < 0 {
+		return callPath, 0
+	}
+	line, err := strconv.Atoi(callPath[i+1:])
+	if err != nil {
+		return callPath, 0
+	}
+	return callPath[:i], line
+}
+
+func localTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendString(t.Format(logTimeFormat))
+}
+
+// GELF 使用 syslog 的级别数字
+func gelfLevelEncoder(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	switch l {
+	case zapcore.DebugLevel:
+		enc.AppendInt(7)
+	case zapcore.InfoLevel:
+		enc.AppendInt(6)
+	case zapcore.WarnLevel:
+		enc.AppendInt(4)
+	case zapcore.ErrorLevel:
+		enc.AppendInt(3)
+	case zapcore.FatalLevel:
+		enc.AppendInt(1)
+	default:
+		enc.AppendInt(2)
+	}
+}
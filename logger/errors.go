@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"tracedemo/redact"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type stackTracer interface {
+	StackTrace() errors.StackTrace
+}
+
+// 错误链的信息
+type errorDetail struct {
+	chain []string //从外到内每一层的错误信息, 相邻重复的只保留一个
+	cause error    //最内层的错误
+	stack string   //最内层带堆栈的错误记录的堆栈, 即错误最初产生的位置
+}
+
+// Error 的错误: format 本身是 error 时取 format, 否则取 args 中最后一个 error
+func findError(format interface{}, args []interface{}) error {
+	if err, ok := format.(error); ok {
+		return err
+	}
+	for i := len(args) - 1; i >= 0; i-- {
+		if err, ok := args[i].(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// format 为 error 时直接使用错误信息, 不作为格式串, 避免信息中的 % 被解析;
+// 这时 args 可以是 "格式串, 参数..." 作为说明, 例如 logger.Error(ctx, err, "query user %d", id)
+func errorMessage(format interface{}, args []interface{}) string {
+	switch f := format.(type) {
+	case error:
+		if len(args) > 0 {
+			if s, ok := args[0].(string); ok {
+				return fmt.Sprintf(s, args[1:]...) + ": " + f.Error()
+			}
+		}
+		return f.Error()
+	case string:
+		//与 Info 等一致, 没有参数时也按格式处理, 例如 100%% 输出为 100%
+		return fmt.Sprintf(f, args...)
+	}
+	return fmt.Sprint(format)
+}
+
+// 沿着 Unwrap 和 pkg/errors 的 Cause 展开错误链
+func unwrapError(err error) errorDetail {
+	var d errorDetail
+	for e := err; e != nil; e = nextError(e) {
+		msg := e.Error()
+		if len(d.chain) == 0 || d.chain[len(d.chain)-1] != msg {
+			d.chain = append(d.chain, msg)
+		}
+		if st, ok := e.(stackTracer); ok {
+			d.stack = strings.TrimPrefix(fmt.Sprintf("%+v", st.StackTrace()), "\n")
+		}
+		d.cause = e
+	}
+	return d
+}
+
+func nextError(err error) error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Cause() error }:
+		return e.Cause()
+	}
+	return nil
+}
+
+func (d errorDetail) fields() []zap.Field {
+	//数组字段不经过 redactFields, 这里逐个脱敏
+	chain := make([]string, len(d.chain))
+	for i, msg := range d.chain {
+		chain[i] = redact.String(msg)
+	}
+	fields := []zap.Field{
+		zap.Strings("errorChain", chain),
+		zap.String("errorCause", d.cause.Error()),
+		zap.String("errorType", fmt.Sprintf("%T", d.cause)),
+	}
+	if d.stack != "" {
+		fields = append(fields, zap.String("errorStack", d.stack))
+	}
+	return fields
+}
@@ -0,0 +1,27 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestErrorMessage(t *testing.T) {
+	err := errors.New("timeout")
+	cases := []struct {
+		format interface{}
+		args   []interface{}
+		want   string
+	}{
+		{"100%% failed", nil, "100% failed"},
+		{"%d%% failed", []interface{}{50}, "50% failed"},
+		{err, nil, "timeout"},
+		{err, []interface{}{"query %s", "user"}, "query user: timeout"},
+		{42, nil, "42"},
+	}
+	for _, c := range cases {
+		if got := errorMessage(c.format, c.args); got != c.want {
+			t.Errorf("errorMessage(%v, %v) = %q, want %q", c.format, c.args, got, c.want)
+		}
+	}
+}
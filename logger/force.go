@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"tracedemo/tenant"
+)
+
+// 强制采样的请求头
+const (
+	HeaderForceTrace = "X-Force-Trace"          //值不为空、0、false 时强制采样
+	HeaderDebugId    = jaeger.JaegerDebugHeader //jaeger-debug-id, jaeger 后端会把值记录为 tag, 可在 Jaeger UI 中搜索
+	HeaderSiteCode   = tenant.Header            //租户, 在 SamplerConfig.SiteCodes 中时强制采样
+)
+
+var (
+	forcedSiteCodes map[string]bool
+	forcedLock      sync.RWMutex
+)
+
+// 设置全部采样的租户, NewTracer 时按 SamplerConfig.SiteCodes 设置, 也可以在运行时修改
+func SetForcedSiteCodes(siteCodes ...string) {
+	m := make(map[string]bool, len(siteCodes))
+	for _, sc := range siteCodes {
+		m[sc] = true
+	}
+
+	forcedLock.Lock()
+	forcedSiteCodes = m
+	forcedLock.Unlock()
+}
+
+func isForcedSiteCode(siteCode string) bool {
+	if siteCode == "" {
+		return false
+	}
+	forcedLock.RLock()
+	defer forcedLock.RUnlock()
+	return forcedSiteCodes[siteCode]
+}
+
+// 请求是否需要强制采样, header 按名称读取请求头, 例如 http.Header.Get
+func ForceSampling(header func(key string) string) bool {
+	switch strings.ToLower(header(HeaderForceTrace)) {
+	case "", "0", "false":
+	default:
+		return true
+	}
+	if header(HeaderDebugId) != "" {
+		return true
+	}
+	return isForcedSiteCode(header(HeaderSiteCode))
+}
+
+// 租户解析出来后再检查一次: 按子域名、路径、JWT、baggage 解析的租户在创建 span 时还不知道;
+// OpenTelemetry 后端在创建 span 时就决定了采样, 这里只对 jaeger 后端生效
+func ForceSamplingSiteCode(ctx context.Context, siteCode string) {
+	if !isForcedSiteCode(siteCode) {
+		return
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		ext.SamplingPriority.Set(span, 1)
+	}
+}
+
+// 强制采样的 span 选项, 通过 sampling.priority tag 实现, jaeger 和 OpenTelemetry 后端都支持
+func ForceSamplingOption() opentracing.StartSpanOption {
+	return opentracing.Tag{Key: string(ext.SamplingPriority), Value: uint16(1)}
+}
+
+// OpenTelemetry 的采样器没有 sampling.priority 的概念, 这里按 span 创建时的属性强制采样
+type forcedSampler struct {
+	sdktrace.Sampler
+}
+
+func (s forcedSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	for _, a := range p.Attributes {
+		if a.Key != attribute.Key(ext.SamplingPriority) {
+			continue
+		}
+		//bridge 会把 uint16 转为字符串
+		if (a.Value.Type() == attribute.INT64 && a.Value.AsInt64() > 0) || (a.Value.Type() == attribute.STRING && a.Value.AsString() != "0") {
+			return sdktrace.SamplingResult{
+				Decision:   sdktrace.RecordAndSample,
+				Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+			}
+		}
+	}
+	return s.Sampler.ShouldSample(p)
+}
@@ -0,0 +1,181 @@
+package logger
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	globalLevel = zap.NewAtomicLevelAt(zap.DebugLevel)
+
+	overrides    []levelOverride //按 prefix 长度倒序, 最长匹配优先
+	overrideLock sync.RWMutex
+)
+
+// 按包或 callPath 前缀单独设置的级别
+type levelOverride struct {
+	prefix string
+	level  zapcore.Level
+}
+
+// 当前的全局级别
+func GetLevel() string {
+	return globalLevel.Level().String()
+}
+
+// 运行时修改全局级别: debug、info、warn、error
+func SetLevel(level string) error {
+	l, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+	globalLevel.SetLevel(l)
+	return nil
+}
+
+// 为某个包或 callPath 前缀单独设置级别, 例如 SetLevelOverride("tracedemo/db", "debug"),
+// 前缀同时匹配调用方的包导入路径和 callPath, 最长匹配优先
+func SetLevelOverride(prefix string, level string) error {
+	if prefix == "" {
+		return errors.New("level override prefix is empty")
+	}
+	l, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	overrideLock.Lock()
+	defer overrideLock.Unlock()
+
+	for i, o := range overrides {
+		if o.prefix == prefix {
+			overrides[i].level = l
+			return nil
+		}
+	}
+	overrides = append(overrides, levelOverride{prefix: prefix, level: l})
+	sort.SliceStable(overrides, func(i, j int) bool {
+		return len(overrides[i].prefix) > len(overrides[j].prefix)
+	})
+	return nil
+}
+
+// 删除单独设置的级别, 恢复使用全局级别
+func RemoveLevelOverride(prefix string) {
+	overrideLock.Lock()
+	defer overrideLock.Unlock()
+
+	for i, o := range overrides {
+		if o.prefix == prefix {
+			overrides = append(overrides[:i], overrides[i+1:]...)
+			return
+		}
+	}
+}
+
+// 所有单独设置的级别, prefix -> level
+func LevelOverrides() map[string]string {
+	overrideLock.RLock()
+	defer overrideLock.RUnlock()
+
+	ret := make(map[string]string, len(overrides))
+	for _, o := range overrides {
+		ret[o.prefix] = o.level.String()
+	}
+	return ret
+}
+
+func hasLevelOverrides() bool {
+	overrideLock.RLock()
+	defer overrideLock.RUnlock()
+	return len(overrides) > 0
+}
+
+// 任意一个 caller 的包或 callPath 匹配时使用该级别, 最长匹配优先
+func levelEnabled(level zapcore.Level, callers ...caller) bool {
+	overrideLock.RLock()
+	defer overrideLock.RUnlock()
+
+	if len(overrides) > 0 {
+		paths := make([]string, 0, 2*len(callers))
+		for _, c := range callers {
+			paths = append(paths, c.pkg(), c.callPath())
+		}
+		for _, o := range overrides {
+			for _, path := range paths {
+				if strings.HasPrefix(path, o.prefix) {
+					return o.level.Enabled(level)
+				}
+			}
+		}
+	}
+	return globalLevel.Enabled(level)
+}
+
+func parseLevel(level string) (zapcore.Level, error) {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return l, errors.Wrapf(err, "invalid log level %q", level)
+	}
+	return l, nil
+}
+
+type levelPayload struct {
+	Level     string            `json:"level"`
+	Package   string            `json:"package,omitempty"`
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// 日志级别管理接口:
+// GET 返回全局级别和所有单独设置的级别;
+// PUT {"level":"info"} 修改全局级别, {"package":"tracedemo/db","level":"debug"} 单独设置, level 为空时删除单独设置
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req levelPayload
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeLevelError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			var err error
+			switch {
+			case req.Package != "" && req.Level == "":
+				RemoveLevelOverride(req.Package)
+			case req.Package != "":
+				err = SetLevelOverride(req.Package, req.Level)
+			case req.Level == "":
+				//zap 把空字符串解析为 info, 空的请求体不能悄悄把级别改回 info
+				err = errors.New("level is required")
+			default:
+				err = SetLevel(req.Level)
+			}
+			if err != nil {
+				writeLevelError(w, http.StatusBadRequest, err)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			writeLevelError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(levelPayload{Level: GetLevel(), Overrides: LevelOverrides()})
+	})
+}
+
+func writeLevelError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
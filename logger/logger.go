@@ -2,100 +2,182 @@ package logger
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
-	"runtime"
-	"strings"
+	"os"
 	"time"
+	"tracedemo/redact"
 
 	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
 	"github.com/uber/jaeger-client-go"
-	"github.com/uber/jaeger-client-go/config"
-	"github.com/uber/jaeger-client-go/log"
-	"github.com/uber/jaeger-lib/metrics"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 var (
-	logTimeFormat = "2006-01-02T15:04:05.000+08:00"
+	logTimeFormat = "2006-01-02T15:04:05.000Z07:00"
 	zapLogger     *zap.Logger
+	logLayout     layout
 )
 
 //配置默认初始化
 func init() {
-	c := zap.NewProductionConfig()
-	c.EncoderConfig.LevelKey = ""
-	c.EncoderConfig.CallerKey = ""
-	c.EncoderConfig.MessageKey = "logModel"
-	c.EncoderConfig.TimeKey = ""
-	c.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
-	zapLogger, _ = c.Build()
+	if err := Init(Config{}); err != nil {
+		panic(err)
+	}
 }
 
-//初始化 Jaeger client
-func NewJaegerTracer(serviceName string, agentHost string) (tracer opentracing.Tracer, closer io.Closer, err error) {
-	cfg := config.Configuration{
-		ServiceName: serviceName,
-		Sampler: &config.SamplerConfig{
-			Type:  jaeger.SamplerTypeRateLimiting,
-			Param: 10,
-		},
-		Reporter: &config.ReporterConfig{
-			LogSpans:            false,
-			BufferFlushInterval: 1 * time.Second,
-			LocalAgentHostPort:  agentHost,
-		},
+// 按配置构造 zap logger, 返回的 closer 在替换 logger 时关闭文件等输出
+func buildLogger(c Config) (*zap.Logger, layout, []io.Closer, error) {
+	l, err := getLayout(c.Encoding)
+	if err != nil {
+		return nil, l, nil, err
+	}
+
+	sinks := c.Sinks
+	if len(sinks) == 0 {
+		sinks = defaultSinks
 	}
 
-	jLogger := log.StdLogger
-	jMetricsFactory := metrics.NullFactory
+	enc, err := newEncoder(l)
+	if err != nil {
+		return nil, l, nil, err
+	}
+
+	var (
+		cores   []zapcore.Core
+		closers []io.Closer
+	)
+	for _, sc := range sinks {
+		//级别由 levelEnabled 控制, 这里默认放开到 debug 以便按包单独开启
+		level := zapcore.DebugLevel
+		if sc.Level != "" {
+			if level, err = parseLevel(sc.Level); err != nil {
+				closeAll(closers)
+				return nil, l, nil, err
+			}
+		}
 
-	tracer, closer, err = cfg.NewTracer(config.Logger(jLogger), config.Metrics(jMetricsFactory))
-	if err == nil {
-		opentracing.SetGlobalTracer(tracer)
+		ws, closer, err := newSink(sc)
+		if err != nil {
+			closeAll(closers)
+			return nil, l, nil, err
+		}
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+		cores = append(cores, zapcore.NewCore(enc.Clone(), ws, level))
 	}
 
-	return tracer, closer, err
+	//采样由 sampler 按 callPath 和级别处理, 见 SamplingConfig;
+	//不再使用 zap 的 stacktrace, 其中都是 logger 内部的调用, 错误的堆栈见 errorStack
+	zl := zap.New(zapcore.NewTee(cores...), zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+	return zl, l, closers, nil
 }
 
-func Error(ctx context.Context, format interface{}, args ...interface{}) {
-	msg := ""
-	if e, ok := format.(error); ok {
-		msg = fmt.Sprintf(e.Error(), args...)
-	} else if e, ok := format.(string); ok {
-		msg = fmt.Sprintf(e, args...)
+func newEncoder(l layout) (zapcore.Encoder, error) {
+	switch l.encoder {
+	case "json":
+		return zapcore.NewJSONEncoder(l.encoderConfig()), nil
+	case "console":
+		return zapcore.NewConsoleEncoder(l.encoderConfig()), nil
+	case EncodingLogfmt:
+		return newLogfmtEncoder(l.encoderConfig()), nil
 	}
+	return nil, errors.Errorf("unknown zap encoder %q", l.encoder)
+}
 
-	jsonStdOut(ctx, zap.ErrorLevel, msg)
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		c.Close()
+	}
+}
+
+//初始化 Jaeger client, 使用默认配置
+func NewJaegerTracer(serviceName string, agentHost string) (tracer opentracing.Tracer, closer io.Closer, err error) {
+	return NewTracer(DefaultTracerConfig(serviceName, agentHost))
+}
+
+// format 可以是 error 或格式串, 错误链和 pkg/errors 记录的堆栈会作为字段输出
+func Error(ctx context.Context, format interface{}, args ...interface{}) {
+	jsonStdOut(ctx, 0, zap.ErrorLevel, errorMessage(format, args), nil, findError(format, args))
 }
 
 func Warn(ctx context.Context, format string, args ...interface{}) {
-	jsonStdOut(ctx, zap.WarnLevel, fmt.Sprintf(format, args...))
+	jsonStdOut(ctx, 0, zap.WarnLevel, fmt.Sprintf(format, args...), nil, nil)
 }
 
 func Info(ctx context.Context, format string, args ...interface{}) {
-	jsonStdOut(ctx, zap.InfoLevel, fmt.Sprintf(format, args...))
+	jsonStdOut(ctx, 0, zap.InfoLevel, fmt.Sprintf(format, args...), nil, nil)
 }
 
 func Debug(ctx context.Context, format string, args ...interface{}) {
-	jsonStdOut(ctx, zap.DebugLevel, fmt.Sprintf(format, args...))
+	jsonStdOut(ctx, 0, zap.DebugLevel, fmt.Sprintf(format, args...), nil, nil)
 }
 
-//本地打印 Json
-func jsonStdOut(ctx context.Context, level zapcore.Level, msg string) {
-	traceId, spanId := getTraceId(ctx)
-	if ce := zapLogger.Check(level, "zap"); ce != nil {
-		ce.Write(
-			zap.Any("message", JsonLogger{
-				LogTime:  time.Now().Format(logTimeFormat),
-				Level:    level,
-				Content:  msg,
-				CallPath: getCallPath(),
-				TraceId:  traceId,
-				SpanId:   spanId,
-			}),
-		)
+//本地打印日志, fields 与 traceId 等字段平级输出, 具体格式由 Config.Encoding 决定
+// skip 为调用方额外要跳过的层数, 见 WithCallerSkip
+func jsonStdOut(ctx context.Context, skip int, level zapcore.Level, msg string, fields []zap.Field, err error) {
+	span := getSpanInfo(ctx)
+	if level == zapcore.DebugLevel && logConfig.DebugSampledOnly && !span.sampled && !span.debug {
+		return
+	}
+
+	//跳过 jsonStdOut 和对外的日志函数, 取业务代码的位置
+	skip += 2 + logConfig.CallerSkip
+	if !hasLevelOverrides() && !globalLevel.Enabled(level) {
+		return
+	}
+	//按包设置的级别同时匹配直接调用日志的包和跳过 SkipCallerPackages 后的业务代码
+	c := reportCaller(skip)
+	if !levelEnabled(level, getCaller(skip), c) {
+		return
+	}
+	callPath := c.callPath()
+	if !logSampler.allow(span.sampled, level, callPath, msg) {
+		return
+	}
+	function := ""
+	if logConfig.CallerFunction {
+		function = c.functionName()
+	}
+	msg = redact.String(msg)
+
+	var detail *errorDetail
+	if err != nil {
+		d := unwrapError(err)
+		detail = &d
+		fields = append(d.fields(), fields...)
+	}
+	record := &jsonRecord{
+		JsonLogger: JsonLogger{
+			LogTime:  time.Now().Format(logTimeFormat),
+			Level:    level,
+			Content:  msg,
+			CallPath: callPath,
+			Function: function,
+			TraceId:  span.traceId,
+			SpanId:   span.spanId,
+			SiteCode: siteCodeFrom(ctx),
+			Service:  logConfig.ServiceName,
+			Hostname: logConfig.Hostname,
+			Version:  logConfig.Version,
+			Env:      logConfig.Env,
+		},
+		context: logLayout.rename(redactFields(contextFields(ctx))),
+		fields:  logLayout.rename(redactFields(fields)),
+	}
+
+	message, entryFields := logLayout.entry(record)
+	if ce := zapLogger.Check(level, message); ce != nil {
+		ce.Write(entryFields...)
+
+		if logConfig.SpanLog {
+			spanLog(ctx, level, msg, callPath, detail)
+		}
 	}
 }
 
@@ -104,35 +186,89 @@ type JsonLogger struct {
 	SpanId   uint64        `json:"spanId"`
 	Content  interface{}   `json:"content"`
 	CallPath interface{}   `json:"callPath"`
-	LogTime  string        `json:"logDate"` //日志时间
-	Level    zapcore.Level `json:"level"`   //日志级别
+	Function string        `json:"function,omitempty"` //调用日志的函数, Config.CallerFunction 开启时输出
+	LogTime  string        `json:"logDate"`            //日志时间
+	Level    zapcore.Level `json:"level"`              //日志级别
+	SiteCode string        `json:"siteCode,omitempty"` //租户
+	Service  string        `json:"service,omitempty"`
+	Hostname string        `json:"hostname,omitempty"`
+	Version  string        `json:"version,omitempty"`
+	Env      string        `json:"env,omitempty"`
 }
 
-func getTraceId(ctx context.Context) (string, uint64) {
-	span := opentracing.SpanFromContext(ctx)
-	if span == nil {
-		return "", 0
+func (l JsonLogger) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("traceId", l.TraceId)
+	enc.AddUint64("spanId", l.SpanId)
+	zap.Any("content", l.Content).AddTo(enc)
+	zap.Any("callPath", l.CallPath).AddTo(enc)
+	addNonEmpty(enc, "function", l.Function)
+	enc.AddString("logDate", l.LogTime)
+	enc.AddString("level", l.Level.String())
+	addNonEmpty(enc, "siteCode", l.SiteCode)
+	addNonEmpty(enc, "service", l.Service)
+	addNonEmpty(enc, "hostname", l.Hostname)
+	addNonEmpty(enc, "version", l.Version)
+	addNonEmpty(enc, "env", l.Env)
+	return nil
+}
+
+func addNonEmpty(enc zapcore.ObjectEncoder, key, value string) {
+	if value != "" {
+		enc.AddString(key, value)
 	}
+}
 
-	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+// 一条日志记录, 上下文提取的字段和结构化字段跟在固定字段后面
+type jsonRecord struct {
+	JsonLogger
+	context []zap.Field
+	fields  []zap.Field
+}
 
-		return fmt.Sprintf("%v", sc.TraceID()), uint64(sc.SpanID())
+func (r jsonRecord) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	r.JsonLogger.MarshalLogObject(enc)
+	for _, f := range r.context {
+		f.AddTo(enc)
+	}
+	for _, f := range r.fields {
+		f.AddTo(enc)
 	}
-	return "", 0
+	return nil
 }
 
-func getCallPath() string {
-	_, file, lineno, ok := runtime.Caller(2)
-	if ok {
-		return strings.Replace(fmt.Sprintf("%s:%d", stringTrim(file, ""), lineno), "%2e", ".", -1)
-	}
-	return ""
+// 上下文中 span 的信息, 每条日志只读取一次
+type spanInfo struct {
+	traceId string
+	spanId  uint64
+	sampled bool
+	debug   bool //jaeger-debug-id 等强制采样的 trace
 }
 
-func stringTrim(s, cut string) string {
-	ss := strings.SplitN(s, cut, 2)
-	if len(ss) == 1 {
-		return ss[0]
+func getSpanInfo(ctx context.Context) spanInfo {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return spanInfo{}
 	}
-	return ss[1]
+
+	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+		return spanInfo{
+			traceId: fmt.Sprintf("%v", sc.TraceID()),
+			spanId:  uint64(sc.SpanID()),
+			sampled: sc.IsSampled(),
+			debug:   sc.IsDebug(),
+		}
+	}
+
+	//OpenTelemetry bridge 在 opentracing.ContextWithSpan 时会把 span 同时写入 OTel 上下文
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		spanId := sc.SpanID()
+		return spanInfo{
+			traceId: sc.TraceID().String(),
+			spanId:  binary.BigEndian.Uint64(spanId[:]),
+			sampled: sc.IsSampled(),
+		}
+	}
+	return spanInfo{}
 }
+
+// 调用日志函数的位置
@@ -0,0 +1,233 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer 后端
+const (
+	TracerBackendJaeger = "jaeger"
+	TracerBackendOTLP   = "otlp"
+)
+
+// OTLP 协议
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+)
+
+// OTLP 导出配置, 未设置的项由 exporter 读取 OTEL_EXPORTER_OTLP_* 环境变量
+type OTLPConfig struct {
+	Protocol string
+	Endpoint string //host:port
+	URLPath  string //仅 http, 默认 /v1/traces
+	Insecure bool
+	Headers  map[string]string
+}
+
+// 基于 OpenTelemetry SDK 初始化 tracer, 通过 opentracing bridge 对外仍然是 opentracing.Tracer
+func newOTelTracer(c *TracerConfig) (opentracing.Tracer, io.Closer, error) {
+	sampler, err := newOTelSampler(&c.Sampler)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := c.Propagators
+	if len(names) == 0 {
+		names = defaultPropagators(c.Backend)
+	}
+	propagator, err := newOTelPropagator(names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attrs := []attribute.KeyValue{semconv.ServiceNameKey.String(c.ServiceName)}
+	for k, v := range c.Tags {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	var batchOpts []sdktrace.BatchSpanProcessorOption
+	if c.Reporter.QueueSize > 0 {
+		batchOpts = append(batchOpts, sdktrace.WithMaxQueueSize(c.Reporter.QueueSize))
+	}
+	if c.Reporter.BufferFlushInterval > 0 {
+		batchOpts = append(batchOpts, sdktrace.WithBatchTimeout(c.Reporter.BufferFlushInterval))
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attrs...)),
+	}
+	//FileOnly 时只写本地文件和内存, 不连接 collector
+	if !c.Reporter.FileOnly || !c.Reporter.local() {
+		exporter, err := newOTLPExporter(&c.OTLP)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter, batchOpts...))
+	}
+	if c.Reporter.File.Filename != "" {
+		opts = append(opts, sdktrace.WithBatcher(&fileExporter{spanFile: newSpanFile(c.Reporter.File)}, batchOpts...))
+	}
+	if store := getSpanStore(); store != nil {
+		//写内存很快, 同步写入, span 结束后立即可查
+		opts = append(opts, sdktrace.WithSyncer(&storeExporter{store: store}))
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	bridge, _ := otbridge.NewTracerPair(provider.Tracer(c.ServiceName))
+	bridge.SetTextMapPropagator(propagator)
+
+	return &otelTracer{BridgeTracer: bridge}, &otelCloser{provider: provider}, nil
+}
+
+func newOTLPExporter(c *OTLPConfig) (*otlptrace.Exporter, error) {
+	switch c.Protocol {
+	case "", OTLPProtocolGRPC:
+		var opts []otlptracegrpc.Option
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(c.Endpoint))
+		}
+		if c.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if len(c.Headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(c.Headers))
+		}
+		return otlptrace.New(context.Background(), otlptracegrpc.NewClient(opts...))
+	case OTLPProtocolHTTP:
+		var opts []otlptracehttp.Option
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(c.Endpoint))
+		}
+		if c.URLPath != "" {
+			opts = append(opts, otlptracehttp.WithURLPath(c.URLPath))
+		}
+		if c.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(c.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(c.Headers))
+		}
+		return otlptrace.New(context.Background(), otlptracehttp.NewClient(opts...))
+	}
+	return nil, fmt.Errorf("unknown otlp protocol %q", c.Protocol)
+}
+
+// 把 jaeger 的采样配置映射为 OpenTelemetry sampler, 有父 span 时沿用父 span 的采样结果
+func newOTelSampler(c *SamplerConfig) (sdktrace.Sampler, error) {
+	var root sdktrace.Sampler
+	switch c.Type {
+	case SamplerTypeConst:
+		if c.Param != 0 {
+			root = sdktrace.AlwaysSample()
+		} else {
+			root = sdktrace.NeverSample()
+		}
+	case SamplerTypeProbabilistic:
+		root = sdktrace.TraceIDRatioBased(c.Param)
+	case SamplerTypeRateLimiting:
+		root = &rateLimitingSampler{
+			limiter: utils.NewRateLimiter(c.Param, math.Max(c.Param, 1.0)),
+			desc:    fmt.Sprintf("RateLimitingSampler{%v}", c.Param),
+		}
+	default:
+		return nil, fmt.Errorf("sampler type %q is not supported by the %s backend", c.Type, TracerBackendOTLP)
+	}
+	return forcedSampler{Sampler: sdktrace.ParentBased(root)}, nil
+}
+
+type rateLimitingSampler struct {
+	limiter utils.RateLimiter
+	desc    string
+}
+
+func (s *rateLimitingSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	decision := sdktrace.Drop
+	if s.limiter.CheckCredit(1.0) {
+		decision = sdktrace.RecordAndSample
+	}
+	return sdktrace.SamplingResult{
+		Decision:   decision,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (s *rateLimitingSampler) Description() string {
+	return s.desc
+}
+
+// bridge 只支持 HTTPHeaders 格式, middleware 使用的是 TextMap + MDCarrier, 这里做一次转换
+type otelTracer struct {
+	*otbridge.BridgeTracer
+}
+
+func (t *otelTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	if format != opentracing.TextMap {
+		return t.BridgeTracer.Inject(sc, format, carrier)
+	}
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+
+	header := http.Header{}
+	if err := t.BridgeTracer.Inject(sc, opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err != nil {
+		return err
+	}
+	for k, vals := range header {
+		for _, v := range vals {
+			//gRPC metadata 的 key 必须小写
+			writer.Set(strings.ToLower(k), v)
+		}
+	}
+	return nil
+}
+
+func (t *otelTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	if format != opentracing.TextMap {
+		return t.BridgeTracer.Extract(format, carrier)
+	}
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return nil, opentracing.ErrInvalidCarrier
+	}
+
+	header := http.Header{}
+	err := reader.ForeachKey(func(key, val string) error {
+		header.Add(key, val)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.BridgeTracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+}
+
+type otelCloser struct {
+	provider *sdktrace.TracerProvider
+}
+
+func (c *otelCloser) Close() error {
+	return c.provider.Shutdown(context.Background())
+}
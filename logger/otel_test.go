@@ -0,0 +1,167 @@
+package logger
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLP 接收端的替身, 记录收到的 span 和请求头
+type otlpReceiver struct {
+	coltracepb.UnimplementedTraceServiceServer
+
+	lock    sync.Mutex
+	spans   []*tracepb.ResourceSpans
+	headers map[string]string
+}
+
+func (r *otlpReceiver) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	headers := make(map[string]string)
+	for k, v := range md {
+		headers[k] = strings.Join(v, ",")
+	}
+	r.record(req, headers)
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func (r *otlpReceiver) record(req *coltracepb.ExportTraceServiceRequest, headers map[string]string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.spans = append(r.spans, req.ResourceSpans...)
+	r.headers = headers
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/custom/v1/traces" {
+		http.NotFound(w, req)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	var export coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	headers := make(map[string]string)
+	for k := range req.Header {
+		headers[strings.ToLower(k)] = req.Header.Get(k)
+	}
+	r.record(&export, headers)
+
+	resp, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+func TestOTLPExportGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := &otlpReceiver{}
+	srv := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(srv, receiver)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	exportSpans(t, OTLPConfig{
+		Protocol: OTLPProtocolGRPC,
+		Endpoint: lis.Addr().String(),
+		Insecure: true,
+		Headers:  map[string]string{"x-token": "secret"},
+	})
+	checkReceived(t, receiver)
+}
+
+func TestOTLPExportHTTP(t *testing.T) {
+	receiver := &otlpReceiver{}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	exportSpans(t, OTLPConfig{
+		Protocol: OTLPProtocolHTTP,
+		Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		URLPath:  "/custom/v1/traces",
+		Insecure: true,
+		Headers:  map[string]string{"x-token": "secret"},
+	})
+	checkReceived(t, receiver)
+}
+
+// 通过 opentracing 接口创建父子两个 span, Close 时全部导出
+func exportSpans(t *testing.T, otlp OTLPConfig) {
+	c := DefaultTracerConfig("otlp-test", "")
+	c.Backend = TracerBackendOTLP
+	c.OTLP = otlp
+	c.Sampler.Type = "const"
+	c.Sampler.Param = 1
+	c.Tags = map[string]string{"env": "test"}
+
+	tracer, closer, err := NewTracer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := tracer.StartSpan("parent", opentracing.Tag{Key: "userId", Value: "42"})
+	child := tracer.StartSpan("child", opentracing.ChildOf(parent.Context()))
+	child.Finish()
+	parent.Finish()
+
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkReceived(t *testing.T, r *otlpReceiver) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.headers["x-token"] != "secret" {
+		t.Errorf("headers = %v, want x-token", r.headers)
+	}
+
+	spans := make(map[string]*tracepb.Span)
+	for _, rs := range r.spans {
+		resource := attributes(rs.Resource.GetAttributes())
+		if resource["service.name"] != "otlp-test" || resource["env"] != "test" {
+			t.Errorf("resource = %v", resource)
+		}
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			for _, s := range ils.Spans {
+				spans[s.Name] = s
+			}
+		}
+	}
+
+	parent, child := spans["parent"], spans["child"]
+	if parent == nil || child == nil {
+		t.Fatalf("received spans = %v, want parent and child", spans)
+	}
+	if string(child.TraceId) != string(parent.TraceId) || string(child.ParentSpanId) != string(parent.SpanId) {
+		t.Errorf("child is not a child of parent: %v / %v", child, parent)
+	}
+	if attrs := attributes(parent.Attributes); attrs["userId"] != "42" {
+		t.Errorf("parent attributes = %v", attrs)
+	}
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]string {
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value.GetStringValue()
+	}
+	return m
+}
@@ -0,0 +1,716 @@
+package logger
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-lib/metrics"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 传播格式, 名称与 OTEL_PROPAGATORS 一致;
+// 注入时写入所有配置的格式, 提取时按配置的顺序使用第一个找到的 trace 上下文, baggage 合并
+const (
+	PropagatorTraceContext = "tracecontext" //W3C traceparent/tracestate
+	PropagatorBaggage      = "baggage"      //W3C baggage
+	PropagatorB3           = "b3"           //B3 单个请求头
+	PropagatorB3Multi      = "b3multi"      //B3 多个请求头 X-B3-*
+	PropagatorJaeger       = "jaeger"       //uber-trace-id 和 uberctx-*
+)
+
+// 默认的传播格式, 与之前的行为一致
+func defaultPropagators(backend string) []string {
+	if backend == TracerBackendOTLP {
+		return []string{PropagatorTraceContext, PropagatorBaggage}
+	}
+	return []string{PropagatorJaeger}
+}
+
+// 与后端无关的 trace 上下文, 各格式的请求头与它互相转换
+type traceParent struct {
+	traceIdHigh uint64
+	traceIdLow  uint64
+	spanId      uint64
+	parentId    uint64 //只有 b3 和 jaeger 格式有
+	sampled     bool
+	debug       bool
+}
+
+// 一种传播格式, h 的 key 为小写; extract 没有找到时返回 nil, nil
+type headerCodec interface {
+	inject(p *traceParent, bag map[string]string, set func(key, value string))
+	extract(h map[string]string) (*traceParent, map[string]string, error)
+}
+
+func newHeaderCodec(name string) (headerCodec, error) {
+	switch name {
+	case PropagatorTraceContext:
+		return traceContextCodec{}, nil
+	case PropagatorBaggage:
+		return baggageCodec{}, nil
+	case PropagatorB3:
+		return b3Codec{}, nil
+	case PropagatorB3Multi:
+		return b3MultiCodec{}, nil
+	case PropagatorJaeger:
+		return jaegerHeaderCodec{}, nil
+	}
+	return nil, fmt.Errorf("unknown propagator %q", name)
+}
+
+func formatTraceId(p *traceParent, fixed bool) string {
+	if p.traceIdHigh == 0 && !fixed {
+		return fmt.Sprintf("%016x", p.traceIdLow)
+	}
+	return fmt.Sprintf("%016x%016x", p.traceIdHigh, p.traceIdLow)
+}
+
+// 解析 16 或 32 位十六进制的 trace id
+func parseTraceId(s string) (high uint64, low uint64, err error) {
+	if len(s) > 32 || len(s) == 0 {
+		return 0, 0, errors.Errorf("invalid trace id %q", s)
+	}
+	if len(s) > 16 {
+		if high, err = strconv.ParseUint(s[:len(s)-16], 16, 64); err != nil {
+			return 0, 0, errors.Wrapf(err, "invalid trace id %q", s)
+		}
+		s = s[len(s)-16:]
+	}
+	if low, err = strconv.ParseUint(s, 16, 64); err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid trace id %q", s)
+	}
+	if high == 0 && low == 0 {
+		return 0, 0, errors.New("trace id is zero")
+	}
+	return high, low, nil
+}
+
+func parseSpanId(s string) (uint64, error) {
+	id, err := strconv.ParseUint(s, 16, 64)
+	if err != nil || len(s) > 16 {
+		return 0, errors.Errorf("invalid span id %q", s)
+	}
+	return id, nil
+}
+
+// jaeger 的 SpanContext 没有 tracestate, 作为这个 baggage 保存, 子 span 继承后原样传给下游;
+// 只有 tracecontext 格式读写, jaeger 和 W3C baggage 格式注入时去掉
+const traceStateBaggageKey = "w3c-tracestate"
+
+// W3C traceparent: 00-{trace id 32 位}-{span id 16 位}-{flags}, tracestate 见 traceStateBaggageKey
+type traceContextCodec struct{}
+
+func (traceContextCodec) inject(p *traceParent, bag map[string]string, set func(key, value string)) {
+	flags := 0
+	if p.sampled {
+		flags = 1
+	}
+	set("traceparent", fmt.Sprintf("00-%s-%016x-%02x", formatTraceId(p, true), p.spanId, flags))
+	if state := bag[traceStateBaggageKey]; state != "" {
+		set("tracestate", state)
+	}
+}
+
+func (traceContextCodec) extract(h map[string]string) (*traceParent, map[string]string, error) {
+	v, ok := h["traceparent"]
+	if !ok {
+		return nil, nil, nil
+	}
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil, nil, errors.Errorf("invalid traceparent %q", v)
+	}
+	//版本 00 只有四段, 更高的版本可以有更多段
+	if parts[0] == "00" && len(parts) != 4 {
+		return nil, nil, errors.Errorf("invalid traceparent %q", v)
+	}
+
+	p := &traceParent{}
+	var err error
+	if p.traceIdHigh, p.traceIdLow, err = parseTraceId(parts[1]); err != nil {
+		return nil, nil, err
+	}
+	if p.spanId, err = parseSpanId(parts[2]); err != nil || p.spanId == 0 {
+		return nil, nil, errors.Errorf("invalid traceparent %q", v)
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return nil, nil, errors.Errorf("invalid traceparent %q", v)
+	}
+	p.sampled = flags&1 == 1
+
+	var bag map[string]string
+	if state := strings.TrimSpace(h["tracestate"]); state != "" {
+		bag = map[string]string{traceStateBaggageKey: state}
+	}
+	return p, bag, nil
+}
+
+// W3C baggage: key1=value1,key2=value2, 只有 baggage 没有 trace 上下文
+type baggageCodec struct{}
+
+func (baggageCodec) inject(p *traceParent, bag map[string]string, set func(key, value string)) {
+	if len(bag) == 0 {
+		return
+	}
+	items := make([]string, 0, len(bag))
+	for k, v := range bag {
+		items = append(items, url.QueryEscape(k)+"="+url.QueryEscape(v))
+	}
+	set("baggage", strings.Join(items, ","))
+}
+
+func (baggageCodec) extract(h map[string]string) (*traceParent, map[string]string, error) {
+	v, ok := h["baggage"]
+	if !ok {
+		return nil, nil, nil
+	}
+	bag := make(map[string]string)
+	for _, item := range strings.Split(v, ",") {
+		//去掉 ;property
+		if i := strings.IndexByte(item, ';'); i >= 0 {
+			item = item[:i]
+		}
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, err1 := url.QueryUnescape(strings.TrimSpace(kv[0]))
+		value, err2 := url.QueryUnescape(strings.TrimSpace(kv[1]))
+		if err1 != nil || err2 != nil || key == "" {
+			continue
+		}
+		bag[key] = value
+	}
+	return nil, bag, nil
+}
+
+// B3 单个请求头: b3: {trace id}-{span id}-{sampled}-{parent span id}, 后两段可选
+type b3Codec struct{}
+
+func (b3Codec) inject(p *traceParent, bag map[string]string, set func(key, value string)) {
+	v := fmt.Sprintf("%s-%016x-%s", formatTraceId(p, false), p.spanId, b3Sampled(p))
+	if p.parentId != 0 {
+		v += fmt.Sprintf("-%016x", p.parentId)
+	}
+	set("b3", v)
+}
+
+func (b3Codec) extract(h map[string]string) (*traceParent, map[string]string, error) {
+	v, ok := h["b3"]
+	if !ok {
+		return nil, nil, nil
+	}
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	//只有采样标记, 没有 trace 上下文
+	if len(parts) < 2 {
+		return nil, nil, nil
+	}
+	if len(parts) > 4 {
+		return nil, nil, errors.Errorf("invalid b3 header %q", v)
+	}
+
+	p := &traceParent{}
+	var err error
+	if p.traceIdHigh, p.traceIdLow, err = parseTraceId(parts[0]); err != nil {
+		return nil, nil, err
+	}
+	if p.spanId, err = parseSpanId(parts[1]); err != nil {
+		return nil, nil, err
+	}
+	if len(parts) > 2 {
+		if err = parseB3Sampled(p, parts[2]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(parts) > 3 {
+		if p.parentId, err = parseSpanId(parts[3]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return p, nil, nil
+}
+
+// B3 多个请求头: X-B3-TraceId、X-B3-SpanId、X-B3-ParentSpanId、X-B3-Sampled、X-B3-Flags
+type b3MultiCodec struct{}
+
+func (b3MultiCodec) inject(p *traceParent, bag map[string]string, set func(key, value string)) {
+	set("x-b3-traceid", formatTraceId(p, false))
+	set("x-b3-spanid", fmt.Sprintf("%016x", p.spanId))
+	if p.parentId != 0 {
+		set("x-b3-parentspanid", fmt.Sprintf("%016x", p.parentId))
+	}
+	if p.debug {
+		set("x-b3-flags", "1")
+	} else {
+		set("x-b3-sampled", b3Sampled(p))
+	}
+}
+
+func (b3MultiCodec) extract(h map[string]string) (*traceParent, map[string]string, error) {
+	traceId, ok := h["x-b3-traceid"]
+	if !ok {
+		return nil, nil, nil
+	}
+
+	p := &traceParent{}
+	var err error
+	if p.traceIdHigh, p.traceIdLow, err = parseTraceId(traceId); err != nil {
+		return nil, nil, err
+	}
+	if p.spanId, err = parseSpanId(h["x-b3-spanid"]); err != nil {
+		return nil, nil, err
+	}
+	if v, ok := h["x-b3-parentspanid"]; ok {
+		if p.parentId, err = parseSpanId(v); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err = parseB3Sampled(p, h["x-b3-sampled"]); err != nil {
+		return nil, nil, err
+	}
+	if h["x-b3-flags"] == "1" {
+		p.sampled, p.debug = true, true
+	}
+	return p, nil, nil
+}
+
+func b3Sampled(p *traceParent) string {
+	switch {
+	case p.debug:
+		return "d"
+	case p.sampled:
+		return "1"
+	}
+	return "0"
+}
+
+// 为空时由下游决定是否采样, 按未采样处理
+func parseB3Sampled(p *traceParent, v string) error {
+	switch strings.ToLower(v) {
+	case "", "0", "false":
+	case "1", "true":
+		p.sampled = true
+	case "d":
+		p.sampled, p.debug = true, true
+	default:
+		return errors.Errorf("invalid b3 sampled %q", v)
+	}
+	return nil
+}
+
+// jaeger: uber-trace-id: {trace id}:{span id}:{parent span id}:{flags}, baggage 为 uberctx-{key}
+type jaegerHeaderCodec struct{}
+
+func (jaegerHeaderCodec) inject(p *traceParent, bag map[string]string, set func(key, value string)) {
+	var flags byte
+	if p.sampled {
+		flags |= 1
+	}
+	if p.debug {
+		flags |= 2
+	}
+	set(jaeger.TraceContextHeaderName, fmt.Sprintf("%s:%x:%x:%x", formatTraceId(p, false), p.spanId, p.parentId, flags))
+	for k, v := range bag {
+		set(jaeger.TraceBaggageHeaderPrefix+strings.ToLower(k), url.PathEscape(v))
+	}
+}
+
+func (jaegerHeaderCodec) extract(h map[string]string) (*traceParent, map[string]string, error) {
+	var bag map[string]string
+	for k, v := range h {
+		if strings.HasPrefix(k, jaeger.TraceBaggageHeaderPrefix) {
+			if bag == nil {
+				bag = make(map[string]string)
+			}
+			if unescaped, err := url.PathUnescape(v); err == nil {
+				v = unescaped
+			}
+			bag[k[len(jaeger.TraceBaggageHeaderPrefix):]] = v
+		}
+	}
+
+	v, ok := h[jaeger.TraceContextHeaderName]
+	if !ok {
+		return nil, bag, nil
+	}
+	if unescaped, err := url.QueryUnescape(v); err == nil {
+		v = unescaped
+	}
+	parts := strings.Split(v, ":")
+	if len(parts) != 4 {
+		return nil, nil, errors.Errorf("invalid %s %q", jaeger.TraceContextHeaderName, v)
+	}
+
+	p := &traceParent{}
+	var err error
+	if p.traceIdHigh, p.traceIdLow, err = parseTraceId(parts[0]); err != nil {
+		return nil, nil, err
+	}
+	if p.spanId, err = parseSpanId(parts[1]); err != nil {
+		return nil, nil, err
+	}
+	if p.parentId, err = parseSpanId(parts[2]); err != nil {
+		return nil, nil, err
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return nil, nil, errors.Errorf("invalid %s %q", jaeger.TraceContextHeaderName, v)
+	}
+	p.sampled, p.debug = flags&1 == 1, flags&2 == 2
+	return p, bag, nil
+}
+
+// TextMap 和 HTTPHeaders 的 key 统一转为小写
+func readHeaders(carrier interface{}) (map[string]string, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return nil, opentracing.ErrInvalidCarrier
+	}
+	h := make(map[string]string)
+	err := reader.ForeachKey(func(key, val string) error {
+		h[strings.ToLower(key)] = val
+		return nil
+	})
+	return h, err
+}
+
+// jaeger 后端: 实现 jaeger.Injector 和 jaeger.Extractor, 注册到 TextMap 和 HTTPHeaders 格式;
+// jaeger 格式使用 jaeger 自己的实现, 支持 jaeger-debug-id; tracestate 见 traceStateBaggageKey
+type jaegerPropagator struct {
+	injectors  []jaeger.Injector
+	extractors []jaeger.Extractor
+	baggage    bool //W3C baggage
+	debug      bool //配置了 jaeger 格式, 有 jaeger-debug-id 时强制采样
+}
+
+func newJaegerPropagator(names []string, native *jaeger.TextMapPropagator) (*jaegerPropagator, error) {
+	p := &jaegerPropagator{}
+	for _, name := range names {
+		switch name {
+		case PropagatorJaeger:
+			p.injectors = append(p.injectors, native)
+			p.extractors = append(p.extractors, native)
+			p.debug = true
+		case PropagatorBaggage:
+			p.baggage = true
+		default:
+			codec, err := newHeaderCodec(name)
+			if err != nil {
+				return nil, err
+			}
+			c := jaegerCodec{codec: codec}
+			p.injectors = append(p.injectors, c)
+			p.extractors = append(p.extractors, c)
+		}
+	}
+	return p, nil
+}
+
+func (p *jaegerPropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	//tracestate 只由 tracecontext 格式注入
+	clean := sc.WithBaggageItem(traceStateBaggageKey, "")
+	for _, injector := range p.injectors {
+		ctx := clean
+		if _, ok := injector.(jaegerCodec); ok {
+			ctx = sc
+		}
+		if err := injector.Inject(ctx, carrier); err != nil {
+			return err
+		}
+	}
+	if p.baggage {
+		writer, ok := carrier.(opentracing.TextMapWriter)
+		if !ok {
+			return opentracing.ErrInvalidCarrier
+		}
+		bag := make(map[string]string)
+		clean.ForeachBaggageItem(func(k, v string) bool {
+			bag[k] = v
+			return true
+		})
+		baggageCodec{}.inject(nil, bag, writer.Set)
+	}
+	return nil
+}
+
+func (p *jaegerPropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	var (
+		sc        jaeger.SpanContext
+		found     bool
+		container *jaeger.SpanContext  //只有 jaeger-debug-id 或 uberctx-* 没有 trace 上下文
+		others    []jaeger.SpanContext //其他格式, 只合并 baggage
+		lastErr   error                = opentracing.ErrSpanContextNotFound
+	)
+	for _, extractor := range p.extractors {
+		ctx, err := extractor.Extract(carrier)
+		switch {
+		case err == nil && !ctx.IsValid():
+			//例如同时有 jaeger-debug-id 和 traceparent 时沿用 traceparent 的 trace
+			if container == nil {
+				container = &ctx
+			}
+			others = append(others, ctx)
+		case err == nil && !found:
+			sc, found = ctx, true
+		case err == nil:
+			others = append(others, ctx)
+		case err != opentracing.ErrSpanContextNotFound:
+			lastErr = err
+		}
+	}
+	if !found && container == nil {
+		return jaeger.SpanContext{}, lastErr
+	}
+	if !found {
+		//由 jaeger 开始一个新的 trace
+		return *container, nil
+	}
+
+	h, err := readHeaders(carrier)
+	if err != nil {
+		return jaeger.SpanContext{}, err
+	}
+	for _, other := range others {
+		other.ForeachBaggageItem(func(k, v string) bool {
+			sc = sc.WithBaggageItem(k, v)
+			return true
+		})
+	}
+	if p.debug && h[jaeger.JaegerDebugHeader] != "" && !sc.IsDebug() {
+		sc = withDebug(sc)
+	}
+	if p.baggage {
+		_, bag, _ := baggageCodec{}.extract(h)
+		for k, v := range bag {
+			sc = sc.WithBaggageItem(k, v)
+		}
+	}
+	return sc, nil
+}
+
+// jaeger.NewSpanContext 只能设置 sampled, 通过 ContextFromString 设置 debug 标记
+func newJaegerContext(p *traceParent, bag map[string]string) jaeger.SpanContext {
+	traceId := jaeger.TraceID{High: p.traceIdHigh, Low: p.traceIdLow}
+	sc := jaeger.NewSpanContext(traceId, jaeger.SpanID(p.spanId), jaeger.SpanID(p.parentId), p.sampled, bag)
+	if p.debug {
+		sc = withDebug(sc)
+	}
+	return sc
+}
+
+func withDebug(sc jaeger.SpanContext) jaeger.SpanContext {
+	traceId := sc.TraceID()
+	debug, err := jaeger.ContextFromString(fmt.Sprintf("%s:%x:%x:%d", traceId, uint64(sc.SpanID()), uint64(sc.ParentID()), sc.Flags()|3))
+	if err != nil {
+		return sc
+	}
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		debug = debug.WithBaggageItem(k, v)
+		return true
+	})
+	return debug
+}
+
+// 把 headerCodec 转为 jaeger.Injector 和 jaeger.Extractor
+type jaegerCodec struct {
+	codec headerCodec
+}
+
+func (c jaegerCodec) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	p := &traceParent{
+		traceIdHigh: sc.TraceID().High,
+		traceIdLow:  sc.TraceID().Low,
+		spanId:      uint64(sc.SpanID()),
+		parentId:    uint64(sc.ParentID()),
+		sampled:     sc.IsSampled(),
+		debug:       sc.IsDebug(),
+	}
+	bag := make(map[string]string)
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		bag[k] = v
+		return true
+	})
+	c.codec.inject(p, bag, writer.Set)
+	return nil
+}
+
+func (c jaegerCodec) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	h, err := readHeaders(carrier)
+	if err != nil {
+		return jaeger.SpanContext{}, err
+	}
+	p, bag, err := c.codec.extract(h)
+	if err != nil {
+		return jaeger.SpanContext{}, err
+	}
+	if p == nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+	return newJaegerContext(p, bag), nil
+}
+
+// jaeger 后端按配置注册 TextMap 和 HTTPHeaders 的 Injector/Extractor, 只有 jaeger 格式时使用 jaeger 的默认实现
+func (c *TracerConfig) jaegerPropagators(factory metrics.Factory) (textMap *jaegerPropagator, httpHeaders *jaegerPropagator, err error) {
+	names := c.Propagators
+	if len(names) == 0 || (len(names) == 1 && names[0] == PropagatorJaeger) {
+		return nil, nil, nil
+	}
+
+	headers := (&jaeger.HeadersConfig{}).ApplyDefaults()
+	m := jaeger.NewMetrics(factory, nil)
+	if textMap, err = newJaegerPropagator(names, jaeger.NewTextMapPropagator(headers, *m)); err != nil {
+		return nil, nil, err
+	}
+	if httpHeaders, err = newJaegerPropagator(names, jaeger.NewHTTPHeaderPropagator(headers, *m)); err != nil {
+		return nil, nil, err
+	}
+	return textMap, httpHeaders, nil
+}
+
+// OpenTelemetry 后端: tracecontext 和 baggage 使用 SDK 的实现, 其他格式把 headerCodec 转为 TextMapPropagator
+func newOTelPropagator(names []string) (propagation.TextMapPropagator, error) {
+	p := &otelPropagator{}
+	for _, name := range names {
+		switch name {
+		case PropagatorTraceContext:
+			p.traces = append(p.traces, propagation.TraceContext{})
+		case PropagatorBaggage:
+			p.baggage = append(p.baggage, propagation.Baggage{})
+		default:
+			codec, err := newHeaderCodec(name)
+			if err != nil {
+				return nil, err
+			}
+			p.traces = append(p.traces, otelCodec{codec: codec})
+		}
+	}
+	return p, nil
+}
+
+// 与 propagation.NewCompositeTextMapPropagator 不同, 提取时使用第一个找到的 trace 上下文, 而不是最后一个
+type otelPropagator struct {
+	traces  []propagation.TextMapPropagator
+	baggage []propagation.TextMapPropagator
+}
+
+func (p *otelPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	for _, t := range p.traces {
+		t.Inject(ctx, carrier)
+	}
+	for _, b := range p.baggage {
+		b.Inject(ctx, carrier)
+	}
+}
+
+func (p *otelPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	for _, t := range p.traces {
+		if next := t.Extract(ctx, carrier); trace.SpanContextFromContext(next).IsValid() {
+			ctx = next
+			break
+		}
+	}
+	for _, b := range p.baggage {
+		ctx = b.Extract(ctx, carrier)
+	}
+	return ctx
+}
+
+func (p *otelPropagator) Fields() []string {
+	var fields []string
+	for _, t := range append(append([]propagation.TextMapPropagator{}, p.traces...), p.baggage...) {
+		fields = append(fields, t.Fields()...)
+	}
+	return fields
+}
+
+type otelCodec struct {
+	codec headerCodec
+}
+
+func (c otelCodec) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	traceId, spanId := sc.TraceID(), sc.SpanID()
+	p := &traceParent{
+		traceIdHigh: binary.BigEndian.Uint64(traceId[:8]),
+		traceIdLow:  binary.BigEndian.Uint64(traceId[8:]),
+		spanId:      binary.BigEndian.Uint64(spanId[:]),
+		sampled:     sc.IsSampled(),
+	}
+	var bag map[string]string
+	if members := baggage.FromContext(ctx).Members(); len(members) > 0 {
+		bag = make(map[string]string, len(members))
+		for _, m := range members {
+			bag[m.Key()] = m.Value()
+		}
+	}
+	c.codec.inject(p, bag, carrier.Set)
+}
+
+func (c otelCodec) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	h := make(map[string]string)
+	for _, k := range carrier.Keys() {
+		h[strings.ToLower(k)] = carrier.Get(k)
+	}
+	p, bag, err := c.codec.extract(h)
+	if err != nil || p == nil {
+		return ctx
+	}
+
+	var traceId trace.TraceID
+	var spanId trace.SpanID
+	binary.BigEndian.PutUint64(traceId[:8], p.traceIdHigh)
+	binary.BigEndian.PutUint64(traceId[8:], p.traceIdLow)
+	binary.BigEndian.PutUint64(spanId[:], p.spanId)
+	var flags trace.TraceFlags
+	if p.sampled {
+		flags = trace.FlagsSampled
+	}
+	ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceId,
+		SpanID:     spanId,
+		TraceFlags: flags,
+		Remote:     true,
+	}))
+
+	if len(bag) > 0 {
+		//与 bridge 的 SetBaggageItem 一致, key 使用 http.CanonicalHeaderKey 的形式
+		b := baggage.FromContext(ctx)
+		for k, v := range bag {
+			if m, err := baggage.NewMember(http.CanonicalHeaderKey(k), v); err == nil {
+				b, _ = b.SetMember(m)
+			}
+		}
+		ctx = baggage.ContextWithBaggage(ctx, b)
+	}
+	return ctx
+}
+
+func (c otelCodec) Fields() []string {
+	switch c.codec.(type) {
+	case b3Codec:
+		return []string{"b3"}
+	case b3MultiCodec:
+		return []string{"x-b3-traceid", "x-b3-spanid", "x-b3-parentspanid", "x-b3-sampled", "x-b3-flags"}
+	case jaegerHeaderCodec:
+		return []string{jaeger.TraceContextHeaderName}
+	}
+	return nil
+}
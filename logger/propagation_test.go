@@ -0,0 +1,290 @@
+package logger
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+)
+
+func TestHeaderCodecRoundTrip(t *testing.T) {
+	id128 := traceParent{traceIdHigh: 0x4bf92f3577b34da6, traceIdLow: 0xa3ce929d0e0e4736, spanId: 0x00f067aa0ba902b7, parentId: 0x5b4185666d50f68b, sampled: true}
+	id64 := traceParent{traceIdLow: 0xa3ce929d0e0e4736, spanId: 0x00f067aa0ba902b7, parentId: 0x5b4185666d50f68b, sampled: true}
+	debug := id64
+	debug.debug = true
+	unsampled := id128
+	unsampled.sampled = false
+	bag := map[string]string{"sitecode": "007", "user": "a b,c"}
+
+	cases := []struct {
+		name    string
+		codec   string
+		p       traceParent
+		bag     map[string]string
+		want    traceParent       //与 p 不同时填写
+		wantBag map[string]string //提取出的 baggage
+	}{
+		//traceparent 没有 parent span id 和 debug 标记
+		{"tracecontext 128", PropagatorTraceContext, id128, nil, traceParent{traceIdHigh: id128.traceIdHigh, traceIdLow: id128.traceIdLow, spanId: id128.spanId, sampled: true}, nil},
+		{"tracecontext 64", PropagatorTraceContext, id64, nil, traceParent{traceIdLow: id64.traceIdLow, spanId: id64.spanId, sampled: true}, nil},
+		{"tracecontext tracestate", PropagatorTraceContext, unsampled, map[string]string{traceStateBaggageKey: "congo=t61rcWkgMzE"}, traceParent{traceIdHigh: id128.traceIdHigh, traceIdLow: id128.traceIdLow, spanId: id128.spanId}, map[string]string{traceStateBaggageKey: "congo=t61rcWkgMzE"}},
+		{"b3 128", PropagatorB3, id128, nil, id128, nil},
+		{"b3 64", PropagatorB3, id64, nil, id64, nil},
+		{"b3 debug", PropagatorB3, debug, nil, debug, nil},
+		{"b3 unsampled", PropagatorB3, unsampled, nil, unsampled, nil},
+		{"b3multi 128", PropagatorB3Multi, id128, nil, id128, nil},
+		{"b3multi 64", PropagatorB3Multi, id64, nil, id64, nil},
+		{"b3multi debug", PropagatorB3Multi, debug, nil, debug, nil},
+		{"jaeger 128", PropagatorJaeger, id128, bag, id128, bag},
+		{"jaeger 64", PropagatorJaeger, id64, nil, id64, nil},
+		{"jaeger debug", PropagatorJaeger, debug, nil, debug, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			codec, err := newHeaderCodec(c.codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := make(map[string]string)
+			codec.inject(&c.p, c.bag, func(k, v string) { h[k] = v })
+
+			p, gotBag, err := codec.extract(h)
+			if err != nil || p == nil {
+				t.Fatalf("extract %v = %v, %v", h, p, err)
+			}
+			if *p != c.want {
+				t.Errorf("extract %v = %+v, want %+v", h, *p, c.want)
+			}
+			if len(gotBag) != 0 || len(c.wantBag) != 0 {
+				if !reflect.DeepEqual(gotBag, c.wantBag) {
+					t.Errorf("baggage = %v, want %v", gotBag, c.wantBag)
+				}
+			}
+		})
+	}
+
+	t.Run("baggage", func(t *testing.T) {
+		h := make(map[string]string)
+		baggageCodec{}.inject(nil, bag, func(k, v string) { h[k] = v })
+		if _, got, _ := (baggageCodec{}).extract(h); !reflect.DeepEqual(got, bag) {
+			t.Errorf("baggage %q = %v, want %v", h["baggage"], got, bag)
+		}
+	})
+}
+
+func TestHeaderCodecMalformed(t *testing.T) {
+	const (
+		traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanId  = "00f067aa0ba902b7"
+	)
+	cases := []struct {
+		name    string
+		codec   string
+		h       map[string]string
+		wantErr bool
+		want    *traceParent //wantErr 为 false 时检查, nil 表示没有找到
+	}{
+		{"traceparent missing", PropagatorTraceContext, map[string]string{"tracestate": "a=b"}, false, nil},
+		{"traceparent short trace id", PropagatorTraceContext, map[string]string{"traceparent": "00-" + traceId[1:] + "-" + spanId + "-01"}, true, nil},
+		{"traceparent long span id", PropagatorTraceContext, map[string]string{"traceparent": "00-" + traceId + "-" + spanId + "0-01"}, true, nil},
+		{"traceparent short flags", PropagatorTraceContext, map[string]string{"traceparent": "00-" + traceId + "-" + spanId + "-1"}, true, nil},
+		{"traceparent version ff", PropagatorTraceContext, map[string]string{"traceparent": "ff-" + traceId + "-" + spanId + "-01"}, true, nil},
+		{"traceparent version 00 extra field", PropagatorTraceContext, map[string]string{"traceparent": "00-" + traceId + "-" + spanId + "-01-xx"}, true, nil},
+		{"traceparent future version extra field", PropagatorTraceContext, map[string]string{"traceparent": "01-" + traceId + "-" + spanId + "-01-xx"}, false,
+			&traceParent{traceIdHigh: 0x4bf92f3577b34da6, traceIdLow: 0xa3ce929d0e0e4736, spanId: 0x00f067aa0ba902b7, sampled: true}},
+		{"traceparent zero trace id", PropagatorTraceContext, map[string]string{"traceparent": "00-" + strings.Repeat("0", 32) + "-" + spanId + "-01"}, true, nil},
+		{"traceparent zero span id", PropagatorTraceContext, map[string]string{"traceparent": "00-" + traceId + "-" + strings.Repeat("0", 16) + "-01"}, true, nil},
+		{"traceparent not hex", PropagatorTraceContext, map[string]string{"traceparent": "00-" + strings.Repeat("g", 32) + "-" + spanId + "-01"}, true, nil},
+
+		{"b3 sampling only", PropagatorB3, map[string]string{"b3": "1"}, false, nil},
+		{"b3 64 bit", PropagatorB3, map[string]string{"b3": "a3ce929d0e0e4736-" + spanId + "-1"}, false,
+			&traceParent{traceIdLow: 0xa3ce929d0e0e4736, spanId: 0x00f067aa0ba902b7, sampled: true}},
+		{"b3 128 bit", PropagatorB3, map[string]string{"b3": traceId + "-" + spanId}, false,
+			&traceParent{traceIdHigh: 0x4bf92f3577b34da6, traceIdLow: 0xa3ce929d0e0e4736, spanId: 0x00f067aa0ba902b7}},
+		{"b3 trace id too long", PropagatorB3, map[string]string{"b3": traceId + "0-" + spanId + "-1"}, true, nil},
+		{"b3 zero trace id", PropagatorB3, map[string]string{"b3": strings.Repeat("0", 16) + "-" + spanId + "-1"}, true, nil},
+		{"b3 bad sampled", PropagatorB3, map[string]string{"b3": traceId + "-" + spanId + "-x"}, true, nil},
+		{"b3 too many fields", PropagatorB3, map[string]string{"b3": traceId + "-" + spanId + "-1-" + spanId + "-1"}, true, nil},
+		{"b3multi bad sampled", PropagatorB3Multi, map[string]string{"x-b3-traceid": traceId, "x-b3-spanid": spanId, "x-b3-sampled": "yes"}, true, nil},
+		{"b3multi true sampled", PropagatorB3Multi, map[string]string{"x-b3-traceid": "a3ce929d0e0e4736", "x-b3-spanid": spanId, "x-b3-sampled": "true"}, false,
+			&traceParent{traceIdLow: 0xa3ce929d0e0e4736, spanId: 0x00f067aa0ba902b7, sampled: true}},
+		{"b3multi missing span id", PropagatorB3Multi, map[string]string{"x-b3-traceid": traceId}, true, nil},
+
+		{"jaeger missing field", PropagatorJaeger, map[string]string{"uber-trace-id": "a3ce929d0e0e4736:" + spanId + ":1"}, true, nil},
+		{"jaeger zero trace id", PropagatorJaeger, map[string]string{"uber-trace-id": "0:" + spanId + ":0:1"}, true, nil},
+		{"jaeger escaped", PropagatorJaeger, map[string]string{"uber-trace-id": "a3ce929d0e0e4736%3A" + spanId + "%3A0%3A3"}, false,
+			&traceParent{traceIdLow: 0xa3ce929d0e0e4736, spanId: 0x00f067aa0ba902b7, sampled: true, debug: true}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			codec, err := newHeaderCodec(c.codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, _, err := codec.extract(c.h)
+			if c.wantErr {
+				if err == nil {
+					t.Errorf("extract %v = %+v, want error", c.h, p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extract %v: %v", c.h, err)
+			}
+			if (p == nil) != (c.want == nil) || (p != nil && *p != *c.want) {
+				t.Errorf("extract %v = %+v, want %+v", c.h, p, c.want)
+			}
+		})
+	}
+}
+
+// 同一个请求带有不同 trace 的多种格式时, 使用配置中第一个能解析的
+func TestPropagatorPrecedence(t *testing.T) {
+	h := http.Header{}
+	h.Set("traceparent", "00-00000000000000000000000000000001-0000000000000001-01")
+	h.Set("b3", "0000000000000002-0000000000000002-1")
+	h.Set("uber-trace-id", "3:3:0:1")
+
+	cases := []struct {
+		propagators []string
+		header      http.Header
+		want        string //trace id
+	}{
+		{[]string{PropagatorTraceContext, PropagatorB3, PropagatorJaeger}, h, "1"},
+		{[]string{PropagatorB3, PropagatorTraceContext, PropagatorJaeger}, h, "2"},
+		{[]string{PropagatorJaeger, PropagatorB3, PropagatorTraceContext}, h, "3"},
+		//第一个格式无法解析时使用下一个
+		{[]string{PropagatorTraceContext, PropagatorB3}, withHeader(h, "traceparent", "ff-00000000000000000000000000000001-0000000000000001-01"), "2"},
+	}
+	for _, backend := range []string{TracerBackendJaeger, TracerBackendOTLP} {
+		for _, c := range cases {
+			t.Run(backend+" "+strings.Join(c.propagators, ","), func(t *testing.T) {
+				tracer := newLocalTracer(t, backend, c.propagators...)
+				sc, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(c.header))
+				if err != nil {
+					t.Fatal(err)
+				}
+				span := tracer.StartSpan("server", opentracing.ChildOf(sc))
+				defer span.Finish()
+
+				out := http.Header{}
+				if err := tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(out)); err != nil {
+					t.Fatal(err)
+				}
+				want := strings.Repeat("0", 31) + c.want
+				if traceparent := out.Get("traceparent"); traceparent != "" && !strings.Contains(traceparent, want) {
+					t.Errorf("traceparent = %q, want trace %s", traceparent, c.want)
+				}
+				if b3 := out.Get("b3"); b3 != "" && !strings.HasPrefix(strings.TrimLeft(b3, "0"), c.want+"-") {
+					t.Errorf("b3 = %q, want trace %s", b3, c.want)
+				}
+				if uber := out.Get("uber-trace-id"); uber != "" && !strings.HasPrefix(strings.TrimLeft(uber, "0"), c.want+":") {
+					t.Errorf("uber-trace-id = %q, want trace %s", uber, c.want)
+				}
+			})
+		}
+	}
+}
+
+// 只有 jaeger-debug-id 没有 uber-trace-id 时, 不能覆盖 traceparent 的 trace
+func TestJaegerDebugIdWithTraceParent(t *testing.T) {
+	h := http.Header{}
+	h.Set("traceparent", "00-00000000000000000000000000000001-0000000000000001-00")
+	h.Set(jaeger.JaegerDebugHeader, "debug-1")
+	h.Set("uberctx-user", "42")
+
+	for _, propagators := range [][]string{
+		{PropagatorJaeger, PropagatorTraceContext},
+		{PropagatorTraceContext, PropagatorJaeger},
+	} {
+		t.Run(strings.Join(propagators, ","), func(t *testing.T) {
+			tracer := newLocalTracer(t, TracerBackendJaeger, propagators...)
+			extracted, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sc := extracted.(jaeger.SpanContext)
+			if sc.TraceID() != (jaeger.TraceID{Low: 1}) || sc.SpanID() != 1 {
+				t.Errorf("span context = %v, want the traceparent trace", sc)
+			}
+			//traceparent 未采样, jaeger-debug-id 强制采样
+			if !sc.IsSampled() || !sc.IsDebug() {
+				t.Errorf("span context = %v, want debug and sampled", sc)
+			}
+			var user string
+			sc.ForeachBaggageItem(func(k, v string) bool {
+				if k == "user" {
+					user = v
+				}
+				return true
+			})
+			if user != "42" {
+				t.Errorf("baggage user = %q", user)
+			}
+		})
+	}
+
+	//没有其他格式时仍由 jaeger 开始新的 debug trace
+	tracer := newLocalTracer(t, TracerBackendJaeger, PropagatorJaeger, PropagatorTraceContext)
+	only := http.Header{}
+	only.Set(jaeger.JaegerDebugHeader, "debug-1")
+	sc, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(only))
+	if err != nil {
+		t.Fatal(err)
+	}
+	span := tracer.StartSpan("server", opentracing.ChildOf(sc))
+	defer span.Finish()
+	if ctx := span.Context().(jaeger.SpanContext); !ctx.IsDebug() {
+		t.Errorf("span context = %v, want debug", ctx)
+	}
+}
+
+// jaeger 后端通过 w3c-tracestate baggage 转发 tracestate, 不会写入 uberctx-* 和 W3C baggage
+func TestTraceStateBridge(t *testing.T) {
+	in := http.Header{}
+	in.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	in.Set("tracestate", "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7")
+	in.Set("baggage", "user=42")
+
+	for _, backend := range []string{TracerBackendJaeger, TracerBackendOTLP} {
+		t.Run(backend, func(t *testing.T) {
+			tracer := newLocalTracer(t, backend, PropagatorTraceContext, PropagatorJaeger, PropagatorBaggage)
+			sc, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			parent := tracer.StartSpan("server", opentracing.ChildOf(sc))
+			defer parent.Finish()
+			child := tracer.StartSpan("client", opentracing.ChildOf(parent.Context()))
+			defer child.Finish()
+
+			out := http.Header{}
+			if err := tracer.Inject(child.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(out)); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.Get("tracestate"); got != in.Get("tracestate") {
+				t.Errorf("tracestate = %q, want %q", got, in.Get("tracestate"))
+			}
+			if !strings.HasPrefix(out.Get("traceparent"), "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+				t.Errorf("traceparent = %q", out.Get("traceparent"))
+			}
+			for k := range out {
+				if strings.Contains(strings.ToLower(k), traceStateBaggageKey) {
+					t.Errorf("tracestate leaked as %s", k)
+				}
+			}
+			if b := out.Get("baggage"); strings.Contains(b, traceStateBaggageKey) || !strings.Contains(strings.ToLower(b), "user=42") {
+				t.Errorf("baggage = %q", b)
+			}
+		})
+	}
+}
+
+func withHeader(h http.Header, key, value string) http.Header {
+	c := h.Clone()
+	c.Set(key, value)
+	return c
+}
@@ -0,0 +1,29 @@
+package logger
+
+import (
+	"tracedemo/redact"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 按 redact 包的规则对字段脱敏: 敏感字段名替换为 Mask, 字符串和对象按正则替换
+func redactFields(fields []zap.Field) []zap.Field {
+	if len(fields) == 0 {
+		return fields
+	}
+
+	ret := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		switch {
+		case redact.IsSensitiveField(f.Key):
+			f = zap.String(f.Key, redact.Mask())
+		case f.Type == zapcore.StringType:
+			f.String = redact.String(f.String)
+		case f.Type == zapcore.ReflectType:
+			f = zap.Any(f.Key, redact.Interface(f.Interface))
+		}
+		ret[i] = f
+	}
+	return ret
+}
@@ -0,0 +1,176 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"tracedemo/redact"
+)
+
+// 日志采样, 防止故障时同一条错误日志刷满磁盘;
+// 被采样的 trace 中的日志不受限制, 保证在 Jaeger 中能看到完整的日志; 默认关闭
+type SamplingConfig struct {
+	Enabled bool
+	Window  time.Duration  //统计窗口, 默认 1s
+	Initial int            //同一 callPath 的相同消息在一个窗口内输出的条数, 之后的合并为一条 "repeated N times", 默认 100
+	MaxKeys int            //一个窗口内最多跟踪的不同消息数, 超过后不再去重, 默认 10000
+	Rates   map[string]int //级别 -> 一个窗口内最多输出的条数, 例如 {"debug": 1000}, 超出的丢弃并汇总为一条
+}
+
+type dedupKey struct {
+	level    zapcore.Level
+	callPath string
+	msg      string
+}
+
+type sampler struct {
+	cfg   SamplingConfig
+	rates map[zapcore.Level]int
+
+	lock     sync.Mutex
+	seen     map[dedupKey]int
+	counts   map[zapcore.Level]int
+	repeated map[dedupKey]int
+	dropped  map[zapcore.Level]int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var logSampler *sampler
+
+func newSampler(c SamplingConfig) (*sampler, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	if c.Window <= 0 {
+		c.Window = time.Second
+	}
+	if c.Initial <= 0 {
+		c.Initial = 100
+	}
+	if c.MaxKeys <= 0 {
+		c.MaxKeys = 10000
+	}
+
+	s := &sampler{
+		cfg:   c,
+		rates: make(map[zapcore.Level]int, len(c.Rates)),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for level, rate := range c.Rates {
+		l, err := parseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		s.rates[l] = rate
+	}
+	s.reset()
+	go s.run()
+	return s, nil
+}
+
+// 是否输出这条日志, 被丢弃的计入下一次汇总
+func (s *sampler) allow(sampled bool, level zapcore.Level, callPath string, msg string) bool {
+	if s == nil || sampled {
+		return true
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := dedupKey{level: level, callPath: callPath, msg: msg}
+	if n, ok := s.seen[key]; ok || len(s.seen) < s.cfg.MaxKeys {
+		s.seen[key] = n + 1
+		if n >= s.cfg.Initial {
+			s.repeated[key]++
+			return false
+		}
+	}
+
+	if rate, ok := s.rates[level]; ok {
+		if s.counts[level] >= rate {
+			s.dropped[level]++
+			return false
+		}
+		s.counts[level]++
+	}
+	return true
+}
+
+func (s *sampler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.stop:
+			s.flush()
+			return
+		}
+	}
+}
+
+// 结束当前窗口, 输出被合并和丢弃的日志的汇总
+func (s *sampler) flush() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	repeated, dropped := s.repeated, s.dropped
+	s.reset()
+	s.lock.Unlock()
+
+	for key, n := range repeated {
+		writeSummary(key.level, key.callPath, fmt.Sprintf("%s (repeated %d times)", key.msg, n), zap.Int("repeated", n))
+	}
+	for level, n := range dropped {
+		writeSummary(level, "", fmt.Sprintf("rate limit exceeded, dropped %d %s logs", n, level), zap.Int("dropped", n))
+	}
+}
+
+func (s *sampler) reset() {
+	s.seen = make(map[dedupKey]int)
+	s.counts = make(map[zapcore.Level]int)
+	s.repeated = make(map[dedupKey]int)
+	s.dropped = make(map[zapcore.Level]int)
+}
+
+func (s *sampler) Close() error {
+	if s == nil {
+		return nil
+	}
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+func writeSummary(level zapcore.Level, callPath string, msg string, field zap.Field) {
+	record := &jsonRecord{
+		JsonLogger: JsonLogger{
+			LogTime:  time.Now().Format(logTimeFormat),
+			Level:    level,
+			Content:  redact.String(msg),
+			CallPath: callPath,
+			Service:  logConfig.ServiceName,
+			Hostname: logConfig.Hostname,
+			Version:  logConfig.Version,
+			Env:      logConfig.Env,
+		},
+		fields: []zap.Field{field},
+	}
+
+	message, entryFields := logLayout.entry(record)
+	if ce := zapLogger.Check(level, message); ce != nil {
+		ce.Write(entryFields...)
+	}
+}
@@ -0,0 +1,416 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 日志投递的目标格式
+const (
+	ShipFormatLoki          = "loki"
+	ShipFormatElasticsearch = "elasticsearch"
+)
+
+// 批量投递到 Loki 或 Elasticsearch bulk 接口
+type ShipConfig struct {
+	URL     string            //loki: http://loki:3100/loki/api/v1/push, elasticsearch: http://es:9200/_bulk
+	Format  string            //loki 或 elasticsearch, 为空时按 URL 判断, 见 shipFormat
+	Index   string            //elasticsearch 的索引
+	Labels  map[string]string //loki 的 stream 标签
+	Headers map[string]string //例如 Authorization
+
+	QueueSize     int           //内存队列长度, 默认 10000
+	BatchSize     int           //每批最多条数, 默认 500
+	FlushInterval time.Duration //最长攒批时间, 默认 1s
+	Block         bool          //队列满时阻塞写日志的调用方, 默认丢弃
+	MaxRetries    int           //失败重试次数, 默认 3
+	Backoff       time.Duration //首次重试等待时间, 之后每次翻倍, 默认 500ms
+	Timeout       time.Duration //单次请求超时, 默认 5s
+}
+
+// 投递统计
+type ShipStats struct {
+	Sent    uint64 `json:"sent"`    //投递成功的条数
+	Dropped uint64 `json:"dropped"` //队列满被丢弃的条数
+	Failed  uint64 `json:"failed"`  //重试后仍然失败的条数
+	Retries uint64 `json:"retries"` //重试的请求数
+}
+
+var (
+	shippers    []*shipper
+	shipClosed  ShipStats //已关闭的输出的统计, 重新 Init 后计数不清零
+	shipperLock sync.Mutex
+)
+
+// 进程启动以来所有投递输出的统计之和
+func ShippingStats() ShipStats {
+	shipperLock.Lock()
+	defer shipperLock.Unlock()
+
+	total := shipClosed
+	for _, s := range shippers {
+		total.add(s.stats())
+	}
+	return total
+}
+
+func (s *ShipStats) add(o ShipStats) {
+	s.Sent += o.Sent
+	s.Dropped += o.Dropped
+	s.Failed += o.Failed
+	s.Retries += o.Retries
+}
+
+type shipRecord struct {
+	time time.Time
+	line []byte
+}
+
+// 实现 zapcore.WriteSyncer, 每次 Write 是一条编码好的日志
+type shipper struct {
+	cfg    ShipConfig
+	client *http.Client
+	queue  chan shipRecord
+	flush  chan chan struct{}
+	done   chan struct{}
+	closed int32
+	lock   sync.RWMutex //保证 Close 之后不再写入 queue
+
+	sent, dropped, failed, retries uint64
+}
+
+func newShipper(c ShipConfig) (*shipper, error) {
+	if c.URL == "" {
+		return nil, errors.New("http sink requires a url")
+	}
+	c.Format = shipFormat(c)
+	switch c.Format {
+	case ShipFormatLoki, ShipFormatElasticsearch:
+	default:
+		return nil, errors.Errorf("unknown ship format %q", c.Format)
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 10000
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 500
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = 3
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = 500 * time.Millisecond
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+
+	s := &shipper{
+		cfg:    c,
+		client: &http.Client{Timeout: c.Timeout},
+		queue:  make(chan shipRecord, c.QueueSize),
+		flush:  make(chan chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+
+	shipperLock.Lock()
+	shippers = append(shippers, s)
+	shipperLock.Unlock()
+	return s, nil
+}
+
+// 没有配置格式时, URL 中带 _bulk 的为 elasticsearch, 其余为 loki
+func shipFormat(c ShipConfig) string {
+	if c.Format != "" {
+		return c.Format
+	}
+	if strings.Contains(c.URL, "/_bulk") {
+		return ShipFormatElasticsearch
+	}
+	return ShipFormatLoki
+}
+
+func (s *shipper) Write(p []byte) (int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if atomic.LoadInt32(&s.closed) == 1 {
+		atomic.AddUint64(&s.dropped, 1)
+		return len(p), nil
+	}
+
+	//zap 会复用 p, 需要拷贝
+	line := make([]byte, len(bytes.TrimRight(p, "\n")))
+	copy(line, p)
+	r := shipRecord{time: time.Now(), line: line}
+
+	if s.cfg.Block {
+		s.queue <- r
+		return len(p), nil
+	}
+	select {
+	case s.queue <- r:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+	return len(p), nil
+}
+
+// 把队列中已有的日志全部投递后返回
+func (s *shipper) Sync() error {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return nil
+	}
+	done := make(chan struct{})
+	select {
+	case s.flush <- done:
+		<-done
+	case <-s.done:
+	}
+	return nil
+}
+
+func (s *shipper) Close() error {
+	s.lock.Lock()
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		s.lock.Unlock()
+		return nil
+	}
+	close(s.queue)
+	s.lock.Unlock()
+	<-s.done
+
+	shipperLock.Lock()
+	for i, sh := range shippers {
+		if sh == s {
+			shipClosed.add(s.stats())
+			shippers = append(shippers[:i], shippers[i+1:]...)
+			break
+		}
+	}
+	shipperLock.Unlock()
+	return nil
+}
+
+func (s *shipper) stats() ShipStats {
+	return ShipStats{
+		Sent:    atomic.LoadUint64(&s.sent),
+		Dropped: atomic.LoadUint64(&s.dropped),
+		Failed:  atomic.LoadUint64(&s.failed),
+		Retries: atomic.LoadUint64(&s.retries),
+	}
+}
+
+func (s *shipper) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]shipRecord, 0, s.cfg.BatchSize)
+	send := func() {
+		if len(batch) > 0 {
+			s.send(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case r, ok := <-s.queue:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= s.cfg.BatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-s.flush:
+			for n := len(s.queue); n > 0; n-- {
+				batch = append(batch, <-s.queue)
+				if len(batch) >= s.cfg.BatchSize {
+					send()
+				}
+			}
+			send()
+			close(done)
+		}
+	}
+}
+
+// 发送一批日志, 失败时按指数退避重试;
+// elasticsearch 的 bulk 接口整体返回 200 时每条仍可能失败, 只重试其中 429 的部分
+func (s *shipper) send(batch []shipRecord) {
+	backoff := s.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		body, contentType, err := s.encode(batch)
+		if err != nil {
+			atomic.AddUint64(&s.failed, uint64(len(batch)))
+			return
+		}
+
+		resp, retry, err := s.post(body, contentType)
+		if err == nil {
+			if s.cfg.Format != ShipFormatElasticsearch {
+				atomic.AddUint64(&s.sent, uint64(len(batch)))
+				return
+			}
+			if batch = s.bulkResult(batch, resp); len(batch) == 0 {
+				return
+			}
+			retry = true
+		}
+		if !retry || attempt >= s.cfg.MaxRetries {
+			atomic.AddUint64(&s.failed, uint64(len(batch)))
+			return
+		}
+
+		atomic.AddUint64(&s.retries, 1)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// 返回响应内容, 2xx 以外的状态为 err
+func (s *shipper) post(body []byte, contentType string) (resp []byte, retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	r, err := s.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= 200 && r.StatusCode < 300 {
+		resp, err = ioutil.ReadAll(r.Body)
+		return resp, true, err
+	}
+	io.Copy(ioutil.Discard, r.Body)
+	//4xx 除了 429 重试也不会成功
+	retry = r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500
+	return nil, retry, fmt.Errorf("ship logs to %s: %s", s.cfg.URL, r.Status)
+}
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkItemResponse `json:"items"` //action -> 结果, 例如 {"index": {...}}
+}
+
+type bulkItemResponse struct {
+	Status int `json:"status"`
+}
+
+// 按 bulk 响应中每条的状态统计成功和失败, 返回需要重试(429)的日志
+func (s *shipper) bulkResult(batch []shipRecord, resp []byte) []shipRecord {
+	var result bulkResponse
+	//没有失败, 或者响应与请求对不上时, 以 HTTP 状态为准
+	if err := json.Unmarshal(resp, &result); err != nil || !result.Errors || len(result.Items) != len(batch) {
+		atomic.AddUint64(&s.sent, uint64(len(batch)))
+		return nil
+	}
+
+	var retry []shipRecord
+	for i, item := range result.Items {
+		status := 0
+		for _, r := range item {
+			status = r.Status
+		}
+		switch {
+		case status >= 200 && status < 300:
+			atomic.AddUint64(&s.sent, 1)
+		case status == http.StatusTooManyRequests:
+			retry = append(retry, batch[i])
+		default:
+			atomic.AddUint64(&s.failed, 1)
+		}
+	}
+	return retry
+}
+
+func (s *shipper) encode(batch []shipRecord) ([]byte, string, error) {
+	if s.cfg.Format == ShipFormatLoki {
+		return s.encodeLoki(batch)
+	}
+	return s.encodeBulk(batch)
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *shipper) encodeLoki(batch []shipRecord) ([]byte, string, error) {
+	labels := s.cfg.Labels
+	if len(labels) == 0 {
+		labels = map[string]string{"service": logConfig.ServiceName}
+	}
+
+	stream := lokiStream{Stream: labels, Values: make([][2]string, 0, len(batch))}
+	for _, r := range batch {
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(r.time.UnixNano(), 10), string(r.line)})
+	}
+	b, err := json.Marshal(lokiPush{Streams: []lokiStream{stream}})
+	return b, "application/json", err
+}
+
+// elasticsearch bulk 接口, 非 JSON 格式的日志包装成 {"message": ...}
+func (s *shipper) encodeBulk(batch []shipRecord) ([]byte, string, error) {
+	//Index 为空时使用 URL 中的索引, 例如 http://es:9200/logs/_bulk
+	meta := map[string]string{}
+	if s.cfg.Index != "" {
+		meta["_index"] = s.cfg.Index
+	}
+	action, err := json.Marshal(map[string]map[string]string{"index": meta})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	for _, r := range batch {
+		buf.Write(action)
+		buf.WriteByte('\n')
+
+		line := r.line
+		if !strings.HasPrefix(string(line), "{") || !json.Valid(line) {
+			line, err = json.Marshal(map[string]string{
+				"@timestamp": r.time.Format(time.RFC3339Nano),
+				"message":    string(r.line),
+			})
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), "application/x-ndjson", nil
+}
@@ -0,0 +1,291 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录收到的请求, 按 statuses 依次返回状态, 用完后返回 200; responses 依次作为响应内容
+type shipServer struct {
+	*httptest.Server
+
+	lock      sync.Mutex
+	bodies    [][]byte
+	types     []string
+	statuses  []int
+	responses []string
+}
+
+func newShipServer(statuses ...int) *shipServer {
+	s := &shipServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.lock.Lock()
+		s.bodies = append(s.bodies, body)
+		s.types = append(s.types, r.Header.Get("Content-Type"))
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		var resp string
+		if len(s.responses) > 0 {
+			resp, s.responses = s.responses[0], s.responses[1:]
+		}
+		s.lock.Unlock()
+
+		w.WriteHeader(status)
+		w.Write([]byte(resp))
+	}))
+	return s
+}
+
+func (s *shipServer) requests() ([][]byte, []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.bodies, s.types
+}
+
+func newTestShipper(t *testing.T, c ShipConfig) *shipper {
+	if c.FlushInterval == 0 {
+		c.FlushInterval = time.Hour
+	}
+	if c.Backoff == 0 {
+		c.Backoff = time.Millisecond
+	}
+	s, err := newShipper(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestShipperLokiBatches(t *testing.T) {
+	srv := newShipServer()
+	defer srv.Close()
+
+	s := newTestShipper(t, ShipConfig{
+		URL:       srv.URL + "/loki/api/v1/push",
+		Format:    ShipFormatLoki,
+		Labels:    map[string]string{"service": "demo"},
+		BatchSize: 2,
+	})
+	for _, line := range []string{`{"n":1}` + "\n", `{"n":2}` + "\n", `{"n":3}` + "\n"} {
+		s.Write([]byte(line))
+	}
+	s.Sync()
+
+	bodies, types := srv.requests()
+	if len(bodies) != 2 {
+		t.Fatalf("requests = %d, want 2", len(bodies))
+	}
+	var lines []string
+	for i, body := range bodies {
+		if types[i] != "application/json" {
+			t.Errorf("content type = %q", types[i])
+		}
+		var push lokiPush
+		if err := json.Unmarshal(body, &push); err != nil {
+			t.Fatal(err)
+		}
+		if len(push.Streams) != 1 || push.Streams[0].Stream["service"] != "demo" {
+			t.Fatalf("streams = %+v", push.Streams)
+		}
+		for _, v := range push.Streams[0].Values {
+			if v[0] == "" {
+				t.Errorf("missing timestamp in %v", v)
+			}
+			lines = append(lines, v[1])
+		}
+	}
+	if want := []string{`{"n":1}`, `{"n":2}`, `{"n":3}`}; !equalStrings(lines, want) {
+		t.Errorf("lines = %v, want %v", lines, want)
+	}
+	if st := s.stats(); st.Sent != 3 || st.Failed != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestShipperElasticsearchBulk(t *testing.T) {
+	srv := newShipServer()
+	defer srv.Close()
+
+	s := newTestShipper(t, ShipConfig{URL: srv.URL + "/_bulk", Index: "logs"})
+	if s.cfg.Format != ShipFormatElasticsearch {
+		t.Fatalf("format = %q, want elasticsearch from the url", s.cfg.Format)
+	}
+	s.Write([]byte(`{"content":"json"}` + "\n"))
+	s.Write([]byte("level=info msg=text\n"))
+	s.Sync()
+
+	bodies, types := srv.requests()
+	if len(bodies) != 1 || types[0] != "application/x-ndjson" {
+		t.Fatalf("requests = %d, content type = %v", len(bodies), types)
+	}
+	var docs []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(bodies[0]))
+	for scanner.Scan() {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			t.Fatalf("invalid ndjson line %q: %v", scanner.Text(), err)
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) != 4 {
+		t.Fatalf("lines = %d, want 4", len(docs))
+	}
+	for _, i := range []int{0, 2} {
+		index, _ := docs[i]["index"].(map[string]interface{})
+		if index["_index"] != "logs" {
+			t.Errorf("action = %v", docs[i])
+		}
+	}
+	if docs[1]["content"] != "json" {
+		t.Errorf("json line = %v", docs[1])
+	}
+	if docs[3]["message"] != "level=info msg=text" || docs[3]["@timestamp"] == nil {
+		t.Errorf("text line = %v", docs[3])
+	}
+}
+
+// bulk 整体返回 200, 其中 429 的重试, 其他失败的计入 Failed
+func TestShipperElasticsearchPartialFailure(t *testing.T) {
+	srv := newShipServer()
+	srv.responses = []string{
+		`{"took":3,"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`,
+		`{"took":1,"errors":false,"items":[{"index":{"status":201}}]}`,
+	}
+	defer srv.Close()
+
+	s := newTestShipper(t, ShipConfig{URL: srv.URL + "/_bulk", MaxRetries: 3})
+	s.Write([]byte(`{"n":1}` + "\n"))
+	s.Write([]byte(`{"n":2}` + "\n"))
+	s.Write([]byte(`{"n":3}` + "\n"))
+	s.Sync()
+
+	bodies, _ := srv.requests()
+	if len(bodies) != 2 {
+		t.Fatalf("requests = %d, want 2", len(bodies))
+	}
+	//重试的请求只有被 429 拒绝的那一条
+	if lines := bytes.Split(bytes.TrimSpace(bodies[1]), []byte("\n")); len(lines) != 2 || string(lines[1]) != `{"n":2}` {
+		t.Errorf("retried bulk = %q", bodies[1])
+	}
+	if st := s.stats(); st.Sent != 2 || st.Failed != 1 || st.Retries != 1 {
+		t.Errorf("stats = %+v, want sent 2, failed 1, retries 1", st)
+	}
+}
+
+// 一直被 429 拒绝, 重试 MaxRetries 次后计入 Failed
+func TestShipperElasticsearchRejected(t *testing.T) {
+	rejected := `{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429}}]}`
+	srv := newShipServer()
+	srv.responses = []string{rejected, `{"errors":true,"items":[{"index":{"status":429}}]}`, `{"errors":true,"items":[{"index":{"status":429}}]}`}
+	defer srv.Close()
+
+	s := newTestShipper(t, ShipConfig{URL: srv.URL + "/_bulk", MaxRetries: 2})
+	s.Write([]byte(`{"n":1}` + "\n"))
+	s.Write([]byte(`{"n":2}` + "\n"))
+	s.Sync()
+
+	if st := s.stats(); st.Sent != 1 || st.Failed != 1 || st.Retries != 2 {
+		t.Errorf("stats = %+v, want sent 1, failed 1, retries 2", st)
+	}
+}
+
+func TestShipperRetries(t *testing.T) {
+	srv := newShipServer(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer srv.Close()
+
+	s := newTestShipper(t, ShipConfig{URL: srv.URL, MaxRetries: 3})
+	s.Write([]byte("a\n"))
+	s.Sync()
+
+	if bodies, _ := srv.requests(); len(bodies) != 3 {
+		t.Errorf("requests = %d, want 3", len(bodies))
+	}
+	if st := s.stats(); st.Sent != 1 || st.Retries != 2 || st.Failed != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestShipperGivesUp(t *testing.T) {
+	srv := newShipServer(http.StatusBadRequest, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	defer srv.Close()
+
+	//4xx 不重试
+	s := newTestShipper(t, ShipConfig{URL: srv.URL, MaxRetries: 2})
+	s.Write([]byte("a\n"))
+	s.Sync()
+	if st := s.stats(); st.Failed != 1 || st.Retries != 0 {
+		t.Errorf("after 400 stats = %+v", st)
+	}
+
+	//5xx 重试 MaxRetries 次后放弃
+	s.Write([]byte("b\n"))
+	s.Sync()
+	if st := s.stats(); st.Failed != 2 || st.Retries != 2 || st.Sent != 0 {
+		t.Errorf("after 500 stats = %+v", st)
+	}
+	if bodies, _ := srv.requests(); len(bodies) != 4 {
+		t.Errorf("requests = %d, want 4", len(bodies))
+	}
+}
+
+func TestShipperDropsWhenFull(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	s := newTestShipper(t, ShipConfig{URL: srv.URL, QueueSize: 1, BatchSize: 1})
+	for i := 0; i < 10; i++ {
+		s.Write([]byte("a\n"))
+	}
+	if st := s.stats(); st.Dropped == 0 {
+		t.Errorf("stats = %+v, want dropped records", st)
+	}
+}
+
+func TestShipFormat(t *testing.T) {
+	cases := []struct {
+		c    ShipConfig
+		want string
+	}{
+		{ShipConfig{URL: "http://loki:3100/loki/api/v1/push"}, ShipFormatLoki},
+		{ShipConfig{URL: "http://es:9200/_bulk"}, ShipFormatElasticsearch},
+		{ShipConfig{URL: "http://es:9200/logs/_bulk"}, ShipFormatElasticsearch},
+		{ShipConfig{URL: "http://es:9200/_bulk", Format: ShipFormatLoki}, ShipFormatLoki},
+	}
+	for _, c := range cases {
+		if got := shipFormat(c.c); got != c.want {
+			t.Errorf("shipFormat(%+v) = %q, want %q", c.c, got, c.want)
+		}
+	}
+
+	if _, err := newShipper(ShipConfig{URL: "http://x", Format: "kafka"}); err == nil {
+		t.Error("unknown format should fail")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// 输出目标
+const (
+	SinkStdout = "stdout"
+	SinkStderr = "stderr"
+	SinkFile   = "file"
+	SinkHTTP   = "http"
+)
+
+// 一个输出目标, 可以配置多个同时输出, 例如 stdout 输出 info, 文件输出 debug
+type SinkConfig struct {
+	Type  string
+	Level string //该输出的最低级别, 为空时不额外过滤
+	File  FileConfig
+	HTTP  ShipConfig
+}
+
+// 文件输出, 按大小和时间滚动
+type FileConfig struct {
+	Filename       string
+	MaxSize        int           //单个文件最大 MB, 默认 100
+	MaxAge         int           //保留天数, 0 为不按时间清理
+	MaxBackups     int           //保留的旧文件个数, 0 为全部保留
+	Compress       bool          //滚动后的文件 gzip 压缩
+	LocalTime      bool          //备份文件名使用本地时间, 默认 UTC
+	RotateInterval time.Duration //按时间滚动的间隔, 例如 24h, 0 为只按大小滚动
+}
+
+// 默认与 zap production 配置一致, 输出到 stderr
+var defaultSinks = []SinkConfig{{Type: SinkStderr}}
+
+func newSink(c SinkConfig) (zapcore.WriteSyncer, io.Closer, error) {
+	switch c.Type {
+	case SinkStdout:
+		return zapcore.Lock(os.Stdout), nil, nil
+	case SinkStderr:
+		return zapcore.Lock(os.Stderr), nil, nil
+	case SinkFile:
+		if c.File.Filename == "" {
+			return nil, nil, errors.New("file sink requires a filename")
+		}
+		f := newRotatingFile(c.File)
+		return zapcore.AddSync(f), f, nil
+	case SinkHTTP:
+		s, err := newShipper(c.HTTP)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s, nil
+	}
+	return nil, nil, errors.Errorf("unknown log sink %q", c.Type)
+}
+
+type rotatingFile struct {
+	*lumberjack.Logger
+	stop chan struct{}
+}
+
+func newRotatingFile(c FileConfig) *rotatingFile {
+	f := &rotatingFile{
+		Logger: &lumberjack.Logger{
+			Filename:   c.Filename,
+			MaxSize:    c.MaxSize,
+			MaxAge:     c.MaxAge,
+			MaxBackups: c.MaxBackups,
+			Compress:   c.Compress,
+			LocalTime:  c.LocalTime,
+		},
+		stop: make(chan struct{}),
+	}
+	if c.RotateInterval > 0 {
+		go f.rotateEvery(c.RotateInterval)
+	}
+	return f
+}
+
+func (f *rotatingFile) rotateEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.Rotate()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *rotatingFile) Close() error {
+	close(f.stop)
+	return f.Logger.Close()
+}
@@ -0,0 +1,42 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"go.uber.org/zap/zapcore"
+	"tracedemo/redact"
+)
+
+// 把日志写入当前 span, 在 Jaeger UI 上可以直接看到
+// 有错误时按 OpenTracing 约定记录 error.kind、error.object 和 stack
+func spanLog(ctx context.Context, level zapcore.Level, msg string, callPath string, err *errorDetail) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	fields := []log.Field{
+		log.String("event", level.String()),
+		log.String("message", msg),
+		log.String("callPath", callPath),
+	}
+	if err != nil {
+		fields = append(fields,
+			log.String("error.kind", fmt.Sprintf("%T", err.cause)),
+			log.String("error.object", redact.String(err.cause.Error())),
+			log.String("error.chain", redact.String(strings.Join(err.chain, "\n"))),
+		)
+		if err.stack != "" {
+			fields = append(fields, log.String("stack", err.stack))
+		}
+	}
+	span.LogFields(fields...)
+	if level >= zapcore.ErrorLevel {
+		ext.Error.Set(span, true)
+	}
+}
@@ -0,0 +1,172 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// 写入本地文件的 span, 每行一个 JSON, 没有 Jaeger agent 的环境用于离线排查
+type spanRecord struct {
+	TraceId      string                 `json:"traceId"`
+	SpanId       string                 `json:"spanId"`
+	ParentSpanId string                 `json:"parentSpanId,omitempty"`
+	Operation    string                 `json:"operationName"`
+	Service      string                 `json:"service,omitempty"`
+	StartTime    time.Time              `json:"startTime"`
+	Duration     int64                  `json:"duration"` //微秒, 与 Jaeger 一致
+	Tags         map[string]interface{} `json:"tags,omitempty"`
+	Logs         []spanLogRecord        `json:"logs,omitempty"`
+}
+
+type spanLogRecord struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Fields    map[string]interface{} `json:"fields"`
+}
+
+type spanFile struct {
+	file *rotatingFile
+}
+
+func newSpanFile(c FileConfig) *spanFile {
+	return &spanFile{file: newRotatingFile(c)}
+}
+
+func (f *spanFile) write(r *spanRecord) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	f.file.Write(append(b, '\n'))
+}
+
+// JSON 无法表示的值转为字符串, 例如 error
+func spanValue(v interface{}) interface{} {
+	switch v.(type) {
+	case string, bool, int, int32, int64, uint16, uint32, uint64, float32, float64:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// 实现 jaeger.Reporter
+type fileReporter struct {
+	*spanFile
+	service string
+}
+
+func newFileReporter(service string, c FileConfig) *fileReporter {
+	return &fileReporter{spanFile: newSpanFile(c), service: service}
+}
+
+func (r *fileReporter) Report(span *jaeger.Span) {
+	r.write(jaegerSpanRecord(r.service, span))
+}
+
+func (r *fileReporter) Close() {
+	r.file.Close()
+}
+
+func jaegerSpanRecord(service string, span *jaeger.Span) *spanRecord {
+	sc := span.SpanContext()
+	record := &spanRecord{
+		TraceId:   sc.TraceID().String(),
+		SpanId:    sc.SpanID().String(),
+		Operation: span.OperationName(),
+		Service:   service,
+		StartTime: span.StartTime(),
+		Duration:  span.Duration().Microseconds(),
+		Tags:      jaegerTags(span.Tags()),
+	}
+	if sc.ParentID() != 0 {
+		record.ParentSpanId = sc.ParentID().String()
+	}
+	for _, l := range span.Logs() {
+		fields := make(map[string]interface{}, len(l.Fields))
+		for _, f := range l.Fields {
+			fields[f.Key()] = spanValue(f.Value())
+		}
+		record.Logs = append(record.Logs, spanLogRecord{Timestamp: l.Timestamp, Fields: fields})
+	}
+	return record
+}
+
+func jaegerTags(tags opentracing.Tags) map[string]interface{} {
+	if len(tags) == 0 {
+		return nil
+	}
+	ret := make(map[string]interface{}, len(tags))
+	for k, v := range tags {
+		ret[k] = spanValue(v)
+	}
+	return ret
+}
+
+// 实现 OpenTelemetry 的 SpanExporter
+type fileExporter struct {
+	*spanFile
+}
+
+func (e *fileExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	for _, s := range spans {
+		e.write(otelSpanRecord(s))
+	}
+	return nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	return e.file.Close()
+}
+
+func otelSpanRecord(s sdktrace.ReadOnlySpan) *spanRecord {
+	record := &spanRecord{
+		TraceId:   s.SpanContext().TraceID().String(),
+		SpanId:    s.SpanContext().SpanID().String(),
+		Operation: s.Name(),
+		StartTime: s.StartTime(),
+		Duration:  s.EndTime().Sub(s.StartTime()).Microseconds(),
+		Tags:      otelAttributes(s.Attributes()),
+	}
+	if s.Parent().IsValid() {
+		record.ParentSpanId = s.Parent().SpanID().String()
+	}
+	if v, ok := s.Resource().Set().Value(semconv.ServiceNameKey); ok {
+		record.Service = v.AsString()
+	}
+	//bridge 把 error tag 转为 span 状态, 这里还原为 tag, 与 jaeger 一致
+	if s.Status().Code == codes.Error {
+		if record.Tags == nil {
+			record.Tags = make(map[string]interface{}, 1)
+		}
+		record.Tags[string(ext.Error)] = true
+	}
+	for _, ev := range s.Events() {
+		fields := otelAttributes(ev.Attributes)
+		if fields == nil {
+			fields = make(map[string]interface{}, 1)
+		}
+		fields["event"] = ev.Name
+		record.Logs = append(record.Logs, spanLogRecord{Timestamp: ev.Time, Fields: fields})
+	}
+	return record
+}
+
+func otelAttributes(attrs []attribute.KeyValue) map[string]interface{} {
+	if len(attrs) == 0 {
+		return nil
+	}
+	ret := make(map[string]interface{}, len(attrs))
+	for _, a := range attrs {
+		ret[string(a.Key)] = a.Value.AsInterface()
+	}
+	return ret
+}
@@ -0,0 +1,425 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"tracedemo/tenant"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/pkg/errors"
+	"github.com/uber/jaeger-client-go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// 内存中保留最近的 span, 开发环境和小规模部署不需要部署 Jaeger 也能查看 trace;
+// 超过容量时覆盖最早的 span, 通过 TraceHandler 查询
+type spanStore struct {
+	lock  sync.RWMutex
+	spans []*spanRecord //环形缓冲
+	next  int
+	full  bool
+}
+
+var (
+	memoryStore *spanStore
+	storeLock   sync.RWMutex
+)
+
+func newSpanStore(size int) *spanStore {
+	return &spanStore{spans: make([]*spanRecord, size)}
+}
+
+// NewTracer 时设置, 为空时 TraceHandler 返回 404
+func setSpanStore(s *spanStore) {
+	storeLock.Lock()
+	memoryStore = s
+	storeLock.Unlock()
+}
+
+func getSpanStore() *spanStore {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+	return memoryStore
+}
+
+func (s *spanStore) add(r *spanRecord) {
+	s.lock.Lock()
+	s.spans[s.next] = r
+	s.next++
+	if s.next == len(s.spans) {
+		s.next = 0
+		s.full = true
+	}
+	s.lock.Unlock()
+}
+
+// 缓冲中的所有 span, 按写入顺序
+func (s *spanStore) all() []*spanRecord {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if !s.full {
+		return append([]*spanRecord(nil), s.spans[:s.next]...)
+	}
+	ret := make([]*spanRecord, 0, len(s.spans))
+	ret = append(ret, s.spans[s.next:]...)
+	return append(ret, s.spans[:s.next]...)
+}
+
+// 实现 jaeger.Reporter
+type storeReporter struct {
+	store   *spanStore
+	service string
+}
+
+func (r *storeReporter) Report(span *jaeger.Span) {
+	r.store.add(jaegerSpanRecord(r.service, span))
+}
+
+func (r *storeReporter) Close() {}
+
+// 实现 OpenTelemetry 的 SpanExporter
+type storeExporter struct {
+	store *spanStore
+}
+
+func (e *storeExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	for _, s := range spans {
+		e.store.add(otelSpanRecord(s))
+	}
+	return nil
+}
+
+func (e *storeExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// trace 列表中的一项
+type traceSummary struct {
+	TraceId   string    `json:"traceId"`
+	Operation string    `json:"operationName"` //根 span 的操作名
+	Services  []string  `json:"services"`
+	SiteCode  string    `json:"siteCode,omitempty"`
+	StartTime time.Time `json:"startTime"`
+	Duration  int64     `json:"duration"` //微秒, 最早开始到最晚结束
+	Spans     int       `json:"spans"`
+	Error     bool      `json:"error"`
+
+	operations map[string]bool
+}
+
+// trace 列表的过滤条件, 对应 TraceHandler 的查询参数
+type traceQuery struct {
+	Operation   string        //任一 span 的操作名
+	SiteCode    string        //任一 span 的 tenant.TagKey tag
+	Error       bool          //只要有错误的 trace
+	MinDuration time.Duration //trace 耗时下限
+	MaxDuration time.Duration //trace 耗时上限, 0 为不限
+	Limit       int           //默认 20
+}
+
+func parseTraceQuery(r *http.Request) (*traceQuery, error) {
+	values := r.URL.Query()
+	q := &traceQuery{
+		Operation: values.Get("operation"),
+		SiteCode:  values.Get("siteCode"),
+		Limit:     20,
+	}
+
+	var err error
+	if v := values.Get("error"); v != "" {
+		if q.Error, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.Wrapf(err, "invalid error %q", v)
+		}
+	}
+	if v := values.Get("minDuration"); v != "" {
+		if q.MinDuration, err = time.ParseDuration(v); err != nil {
+			return nil, errors.Wrapf(err, "invalid minDuration %q", v)
+		}
+	}
+	if v := values.Get("maxDuration"); v != "" {
+		if q.MaxDuration, err = time.ParseDuration(v); err != nil {
+			return nil, errors.Wrapf(err, "invalid maxDuration %q", v)
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			return nil, errors.Errorf("invalid limit %q", v)
+		}
+	}
+	return q, nil
+}
+
+func (q *traceQuery) match(t *traceSummary) bool {
+	if q.Operation != "" && !t.operations[q.Operation] {
+		return false
+	}
+	if q.SiteCode != "" && t.SiteCode != q.SiteCode {
+		return false
+	}
+	if q.Error && !t.Error {
+		return false
+	}
+	d := time.Duration(t.Duration) * time.Microsecond
+	if d < q.MinDuration || (q.MaxDuration > 0 && d > q.MaxDuration) {
+		return false
+	}
+	return true
+}
+
+// 按 trace 汇总, 最新的在前
+func (s *spanStore) traces(q *traceQuery) []*traceSummary {
+	byId := make(map[string]*traceSummary)
+	ends := make(map[string]time.Time)
+	var order []*traceSummary
+
+	for _, span := range s.all() {
+		t := byId[span.TraceId]
+		if t == nil {
+			t = &traceSummary{TraceId: span.TraceId, StartTime: span.StartTime, operations: make(map[string]bool)}
+			byId[span.TraceId] = t
+			order = append(order, t)
+		}
+		t.Spans++
+		t.operations[span.Operation] = true
+		if !containsString(t.Services, span.Service) {
+			t.Services = append(t.Services, span.Service)
+		}
+		if span.ParentSpanId == "" || t.Operation == "" {
+			t.Operation = span.Operation
+		}
+		if sc, ok := span.Tags[tenant.TagKey].(string); ok && t.SiteCode == "" {
+			t.SiteCode = sc
+		}
+		if v, ok := span.Tags[string(ext.Error)].(bool); ok && v {
+			t.Error = true
+		}
+
+		if span.StartTime.Before(t.StartTime) {
+			t.StartTime = span.StartTime
+		}
+		if end := span.StartTime.Add(time.Duration(span.Duration) * time.Microsecond); end.After(ends[t.TraceId]) {
+			ends[t.TraceId] = end
+		}
+	}
+
+	ret := make([]*traceSummary, 0, q.Limit)
+	for _, t := range order {
+		t.Duration = ends[t.TraceId].Sub(t.StartTime).Microseconds()
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].StartTime.After(order[j].StartTime)
+	})
+	for _, t := range order {
+		if len(ret) >= q.Limit {
+			break
+		}
+		if q.match(t) {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// trace 中的 span 及其子 span
+type spanNode struct {
+	*spanRecord
+	Children []*spanNode `json:"children,omitempty"`
+}
+
+// 一个 trace 的 span 树, 父 span 不在缓冲中(上游服务或已被覆盖)的 span 作为根
+func (s *spanStore) trace(traceId string) []*spanNode {
+	nodes := make(map[string]*spanNode)
+	var spans []*spanNode
+	for _, span := range s.all() {
+		if span.TraceId == traceId {
+			n := &spanNode{spanRecord: span}
+			nodes[span.SpanId] = n
+			spans = append(spans, n)
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].StartTime.Before(spans[j].StartTime)
+	})
+
+	var roots []*spanNode
+	for _, n := range spans {
+		if parent := nodes[n.ParentSpanId]; parent != nil && parent != n {
+			parent.Children = append(parent.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+	return roots
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// 内存 trace 查询接口, 需要 ReporterConfig.MemorySpans 大于 0:
+// GET ?operation=&siteCode=&error=true&minDuration=100ms&maxDuration=&limit=20 返回最近的 trace 列表;
+// GET ?traceId=xxx 返回一个 trace 的 span 树; 加上 format=html 返回网页, trace 以瀑布图展示
+func TraceHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		store := getSpanStore()
+		if store == nil {
+			http.Error(w, "span store is not enabled", http.StatusNotFound)
+			return
+		}
+		html := r.URL.Query().Get("format") == "html"
+
+		if traceId := r.URL.Query().Get("traceId"); traceId != "" {
+			roots := store.trace(traceId)
+			if len(roots) == 0 {
+				http.Error(w, "trace not found", http.StatusNotFound)
+				return
+			}
+			if html {
+				writeTraceHTML(w, traceId, roots)
+				return
+			}
+			writeJSON(w, http.StatusOK, roots)
+			return
+		}
+
+		q, err := parseTraceQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		traces := store.traces(q)
+		if html {
+			writeTracesHTML(w, r, traces)
+			return
+		}
+		writeJSON(w, http.StatusOK, traces)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// 瀑布图中的一行
+type waterfallRow struct {
+	*spanRecord
+	Depth  int
+	Offset float64 //相对 trace 开始的位置, 百分比
+	Width  float64 //百分比
+	Error  bool
+}
+
+func writeTraceHTML(w http.ResponseWriter, traceId string, roots []*spanNode) {
+	start, end := roots[0].StartTime, time.Time{}
+	var walk func(nodes []*spanNode, f func(n *spanNode, depth int), depth int)
+	walk = func(nodes []*spanNode, f func(n *spanNode, depth int), depth int) {
+		for _, n := range nodes {
+			f(n, depth)
+			walk(n.Children, f, depth+1)
+		}
+	}
+	walk(roots, func(n *spanNode, depth int) {
+		if n.StartTime.Before(start) {
+			start = n.StartTime
+		}
+		if e := n.StartTime.Add(time.Duration(n.Duration) * time.Microsecond); e.After(end) {
+			end = e
+		}
+	}, 0)
+
+	total := float64(end.Sub(start).Microseconds())
+	if total <= 0 {
+		total = 1
+	}
+	var rows []waterfallRow
+	walk(roots, func(n *spanNode, depth int) {
+		isErr, _ := n.Tags[string(ext.Error)].(bool)
+		rows = append(rows, waterfallRow{
+			spanRecord: n.spanRecord,
+			Depth:      depth,
+			Offset:     float64(n.StartTime.Sub(start).Microseconds()) * 100 / total,
+			Width:      float64(n.Duration) * 100 / total,
+			Error:      isErr,
+		})
+	}, 0)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	traceTemplate.Execute(w, map[string]interface{}{
+		"TraceId":  traceId,
+		"Duration": int64(total),
+		"Rows":     rows,
+	})
+}
+
+func writeTracesHTML(w http.ResponseWriter, r *http.Request, traces []*traceSummary) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tracesTemplate.Execute(w, map[string]interface{}{
+		"Query":  r.URL.Query(),
+		"Traces": traces,
+	})
+}
+
+var templateFuncs = template.FuncMap{
+	"indent": func(depth int) int { return depth * 16 },
+	"micros": func(us int64) string { return (time.Duration(us) * time.Microsecond).String() },
+}
+
+var tracesTemplate = template.Must(template.New("traces").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Traces</title>
+<style>body{font-family:sans-serif;font-size:13px}table{border-collapse:collapse}td,th{padding:4px 8px;border-bottom:1px solid #ddd;text-align:left}.err{color:#c00}</style>
+</head><body>
+<form method="get">
+<input type="hidden" name="format" value="html">
+operation <input name="operation" value="{{.Query.Get "operation"}}">
+siteCode <input name="siteCode" value="{{.Query.Get "siteCode"}}" size="8">
+minDuration <input name="minDuration" value="{{.Query.Get "minDuration"}}" size="6">
+maxDuration <input name="maxDuration" value="{{.Query.Get "maxDuration"}}" size="6">
+<label><input type="checkbox" name="error" value="true"{{if .Query.Get "error"}} checked{{end}}> error</label>
+limit <input name="limit" value="{{.Query.Get "limit"}}" size="4">
+<button>search</button>
+</form>
+<table>
+<tr><th>start</th><th>operation</th><th>services</th><th>siteCode</th><th>duration</th><th>spans</th></tr>
+{{range .Traces}}<tr{{if .Error}} class="err"{{end}}>
+<td>{{.StartTime.Format "15:04:05.000"}}</td>
+<td><a href="?format=html&amp;traceId={{.TraceId}}">{{.Operation}}</a></td>
+<td>{{range $i, $s := .Services}}{{if $i}}, {{end}}{{$s}}{{end}}</td>
+<td>{{.SiteCode}}</td>
+<td>{{micros .Duration}}</td>
+<td>{{.Spans}}</td>
+</tr>{{end}}
+</table>
+</body></html>
+`))
+
+var traceTemplate = template.Must(template.New("trace").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Trace {{.TraceId}}</title>
+<style>body{font-family:sans-serif;font-size:13px}.row{display:flex;border-bottom:1px solid #eee;padding:2px 0}.name{width:360px;flex:none;overflow:hidden;white-space:nowrap}.bar{position:relative;flex:1}.bar div{position:absolute;height:14px;min-width:1px;background:#4a90d9}.err .bar div{background:#d0021b}details{margin-left:360px;color:#555}</style>
+</head><body>
+<p><a href="?format=html">traces</a> / {{.TraceId}} ({{micros .Duration}})</p>
+{{range .Rows}}<div class="row{{if .Error}} err{{end}}">
+<div class="name" style="padding-left:{{indent .Depth}}px" title="{{.Service}} {{.Operation}}">{{.Service}}: {{.Operation}} <small>{{micros .Duration}}</small></div>
+<div class="bar"><div style="left:{{printf "%.3f" .Offset}}%;width:{{printf "%.3f" .Width}}%"></div></div>
+</div>
+<details><summary>{{.SpanId}}</summary>{{range $k, $v := .Tags}}{{$k}}={{$v}}<br>{{end}}{{range .Logs}}{{.Timestamp.Format "15:04:05.000000"}} {{range $k, $v := .Fields}}{{$k}}={{$v}} {{end}}<br>{{end}}</details>
+{{end}}
+</body></html>
+`))
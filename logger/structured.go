@@ -0,0 +1,120 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// 结构化日志, keysAndValues 为交替的 key/value, 例如 logger.Infow(ctx, "create user", "userId", id)
+func Errorw(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	jsonStdOut(ctx, 0, zap.ErrorLevel, msg, sweetenFields(keysAndValues), findError(nil, keysAndValues))
+}
+
+func Warnw(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	jsonStdOut(ctx, 0, zap.WarnLevel, msg, sweetenFields(keysAndValues), nil)
+}
+
+func Infow(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	jsonStdOut(ctx, 0, zap.InfoLevel, msg, sweetenFields(keysAndValues), nil)
+}
+
+func Debugw(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	jsonStdOut(ctx, 0, zap.DebugLevel, msg, sweetenFields(keysAndValues), nil)
+}
+
+// 绑定了上下文和字段的子 logger, 每条日志都会带上这些字段
+type Logger struct {
+	ctx    context.Context
+	fields []zap.Field
+	skip   int
+}
+
+func With(ctx context.Context, keysAndValues ...interface{}) *Logger {
+	return &Logger{ctx: ctx, fields: sweetenFields(keysAndValues)}
+}
+
+// 在当前字段基础上再追加字段, 返回新的子 logger
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	return &Logger{ctx: l.ctx, fields: l.join(sweetenFields(keysAndValues)), skip: l.skip}
+}
+
+// 封装日志的函数使用, 调用位置向上多跳过 skip 层, 例如 logger.WithCallerSkip(ctx, 1).Info(...)
+func WithCallerSkip(ctx context.Context, skip int) *Logger {
+	return &Logger{ctx: ctx, skip: skip}
+}
+
+func (l *Logger) WithCallerSkip(skip int) *Logger {
+	return &Logger{ctx: l.ctx, fields: l.fields, skip: l.skip + skip}
+}
+
+func (l *Logger) Error(format interface{}, args ...interface{}) {
+	jsonStdOut(l.ctx, l.skip, zap.ErrorLevel, errorMessage(format, args), l.fields, findError(format, args))
+}
+
+func (l *Logger) Warn(format string, args ...interface{}) {
+	jsonStdOut(l.ctx, l.skip, zap.WarnLevel, fmt.Sprintf(format, args...), l.fields, nil)
+}
+
+func (l *Logger) Info(format string, args ...interface{}) {
+	jsonStdOut(l.ctx, l.skip, zap.InfoLevel, fmt.Sprintf(format, args...), l.fields, nil)
+}
+
+func (l *Logger) Debug(format string, args ...interface{}) {
+	jsonStdOut(l.ctx, l.skip, zap.DebugLevel, fmt.Sprintf(format, args...), l.fields, nil)
+}
+
+func (l *Logger) Errorw(msg string, keysAndValues ...interface{}) {
+	jsonStdOut(l.ctx, l.skip, zap.ErrorLevel, msg, l.join(sweetenFields(keysAndValues)), findError(nil, keysAndValues))
+}
+
+func (l *Logger) Warnw(msg string, keysAndValues ...interface{}) {
+	jsonStdOut(l.ctx, l.skip, zap.WarnLevel, msg, l.join(sweetenFields(keysAndValues)), nil)
+}
+
+func (l *Logger) Infow(msg string, keysAndValues ...interface{}) {
+	jsonStdOut(l.ctx, l.skip, zap.InfoLevel, msg, l.join(sweetenFields(keysAndValues)), nil)
+}
+
+func (l *Logger) Debugw(msg string, keysAndValues ...interface{}) {
+	jsonStdOut(l.ctx, l.skip, zap.DebugLevel, msg, l.join(sweetenFields(keysAndValues)), nil)
+}
+
+func (l *Logger) join(fields []zap.Field) []zap.Field {
+	if len(fields) == 0 {
+		return l.fields
+	}
+	ret := make([]zap.Field, 0, len(l.fields)+len(fields))
+	ret = append(ret, l.fields...)
+	return append(ret, fields...)
+}
+
+// 把交替的 key/value 转成 zap.Field, 落单的 value 记到 !BADKEY 下, 与 zap.SugaredLogger 一致
+func sweetenFields(keysAndValues []interface{}) []zap.Field {
+	if len(keysAndValues) == 0 {
+		return nil
+	}
+
+	fields := make([]zap.Field, 0, (len(keysAndValues)+1)/2)
+	for i := 0; i < len(keysAndValues); {
+		if f, ok := keysAndValues[i].(zap.Field); ok {
+			fields = append(fields, f)
+			i++
+			continue
+		}
+
+		if i == len(keysAndValues)-1 {
+			fields = append(fields, zap.Any("!BADKEY", keysAndValues[i]))
+			break
+		}
+
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = fmt.Sprintf("%v", keysAndValues[i])
+		}
+		fields = append(fields, zap.Any(key, keysAndValues[i+1]))
+		i += 2
+	}
+	return fields
+}
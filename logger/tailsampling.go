@@ -0,0 +1,326 @@
+package logger
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+	"time"
+	"tracedemo/tenant"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-lib/metrics"
+)
+
+// 尾部采样: 缓存一个 trace 的所有 span, 本进程的根 span 结束后再决定是否上报,
+// 有错误、耗时超过阈值或匹配规则的 trace 全部保留, 其余按 BaseRate 保留;
+// 开启后前置采样固定为全部采样, 仅支持 jaeger 后端
+type TailSamplingConfig struct {
+	Enabled    bool
+	Latency    time.Duration //任一 span 耗时超过该值时保留, 0 为不按耗时
+	Operations []string      //包含这些操作名的 trace 全部保留
+	SiteCodes  []string      //这些租户的 trace 全部保留, 匹配 span 的 tenant.TagKey tag
+	BaseRate   float64       //其余 trace 的保留比例, 0~1
+
+	MaxTraces        int           //最多缓存的 trace 数, 超过时提前决定最早的 trace, 默认 10000
+	MaxSpansPerTrace int           //单个 trace 最多缓存的 span 数, 超过的丢弃, 默认 1000
+	DecisionWait     time.Duration //根 span 一直不结束时, 最多等待多久做决定, 默认 30s
+	MaxDecisions     int           //最多记住的已决定 trace 数, 超过时忘记最早的, 默认 100000
+}
+
+type tailMetrics struct {
+	Buffered     metrics.Gauge   `metric:"tail_sampling_buffered_traces" help:"traces waiting for a tail sampling decision"`
+	Kept         metrics.Counter `metric:"tail_sampling_traces" tags:"result=kept" help:"traces by tail sampling decision"`
+	Dropped      metrics.Counter `metric:"tail_sampling_traces" tags:"result=dropped" help:"traces by tail sampling decision"`
+	Evicted      metrics.Counter `metric:"tail_sampling_evicted_traces" help:"traces decided early because the buffer is full"`
+	DroppedSpans metrics.Counter `metric:"tail_sampling_dropped_spans" help:"spans dropped because the trace has too many spans"`
+}
+
+type tailTrace struct {
+	id      jaeger.TraceID
+	spans   []*jaeger.Span
+	local   map[jaeger.SpanID]bool //本进程创建的 span, 父 span 不在其中的是本进程的根 span
+	start   time.Time
+	matched bool
+	elem    *list.Element
+}
+
+type tailDecision struct {
+	keep bool
+	at   time.Time
+}
+
+type decidedTrace struct {
+	id jaeger.TraceID
+	at time.Time
+}
+
+// 实现 jaeger.Reporter, 保留的 trace 交给 next 上报
+type tailReporter struct {
+	cfg        TailSamplingConfig
+	next       jaeger.Reporter
+	metrics    tailMetrics
+	operations map[string]bool
+	siteCodes  map[string]bool
+
+	lock    sync.Mutex
+	traces  map[jaeger.TraceID]*tailTrace
+	order   *list.List //按开始缓存的时间排序
+	decided map[jaeger.TraceID]tailDecision
+	expires *list.List //按决定的时间排序的 decidedTrace
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newTailReporter(c TailSamplingConfig, next jaeger.Reporter, factory metrics.Factory) *tailReporter {
+	if c.MaxTraces <= 0 {
+		c.MaxTraces = 10000
+	}
+	if c.MaxSpansPerTrace <= 0 {
+		c.MaxSpansPerTrace = 1000
+	}
+	if c.DecisionWait <= 0 {
+		c.DecisionWait = 30 * time.Second
+	}
+	if c.MaxDecisions <= 0 {
+		c.MaxDecisions = 100000
+	}
+
+	r := &tailReporter{
+		cfg:        c,
+		next:       next,
+		operations: make(map[string]bool, len(c.Operations)),
+		siteCodes:  make(map[string]bool, len(c.SiteCodes)),
+		traces:     make(map[jaeger.TraceID]*tailTrace),
+		order:      list.New(),
+		decided:    make(map[jaeger.TraceID]tailDecision),
+		expires:    list.New(),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for _, op := range c.Operations {
+		r.operations[op] = true
+	}
+	for _, sc := range c.SiteCodes {
+		r.siteCodes[sc] = true
+	}
+	metrics.MustInit(&r.metrics, factory.Namespace(metrics.NSOptions{Name: "jaeger"}).Namespace(metrics.NSOptions{Name: "tracer"}), nil)
+
+	go r.run()
+	return r
+}
+
+// 实现 jaeger.ContribObserver, 记录本进程创建的 span;
+// 同一进程内 HTTP 调用 gRPC 时, 内层 gRPC 服务端的 span 不是根 span, 要等外层的 HTTP span 结束
+func (r *tailReporter) OnStartSpan(sp opentracing.Span, operationName string, options opentracing.StartSpanOptions) (jaeger.ContribSpanObserver, bool) {
+	span, ok := sp.(*jaeger.Span)
+	if !ok {
+		return nil, false
+	}
+	ctx := span.SpanContext()
+
+	r.lock.Lock()
+	var flush []*tailTrace
+	if _, ok := r.decided[ctx.TraceID()]; !ok {
+		var t *tailTrace
+		t, flush = r.trace(ctx.TraceID())
+		t.local[ctx.SpanID()] = true
+	}
+	r.lock.Unlock()
+
+	r.flush(flush)
+	return nil, false
+}
+
+func (r *tailReporter) Report(span *jaeger.Span) {
+	id := span.SpanContext().TraceID()
+
+	r.lock.Lock()
+	//根 span 结束后才结束的异步 span 沿用已有的决定
+	if d, ok := r.decided[id]; ok {
+		r.lock.Unlock()
+		if d.keep {
+			r.next.Report(span)
+		}
+		return
+	}
+
+	t, flush := r.trace(id)
+	if len(t.spans) < r.cfg.MaxSpansPerTrace {
+		span.Retain()
+		t.spans = append(t.spans, span)
+		t.matched = t.matched || r.match(span)
+	} else {
+		r.metrics.DroppedSpans.Inc(1)
+	}
+
+	//本进程内的根 span: 没有父 span, 或者父 span 来自其他进程
+	if parent := span.SpanContext().ParentID(); parent == 0 || !t.local[parent] {
+		r.remove(t)
+		flush = append(flush, t)
+	}
+	r.metrics.Buffered.Update(int64(len(r.traces)))
+	r.lock.Unlock()
+
+	r.flush(flush)
+}
+
+// 取出或创建缓存的 trace, 缓存已满时返回被提前决定的最早的 trace; 需要持有锁
+func (r *tailReporter) trace(id jaeger.TraceID) (*tailTrace, []*tailTrace) {
+	if t := r.traces[id]; t != nil {
+		return t, nil
+	}
+
+	var flush []*tailTrace
+	if len(r.traces) >= r.cfg.MaxTraces {
+		oldest := r.order.Front().Value.(*tailTrace)
+		r.remove(oldest)
+		flush = append(flush, oldest)
+		r.metrics.Evicted.Inc(1)
+	}
+	t := &tailTrace{id: id, local: make(map[jaeger.SpanID]bool), start: time.Now()}
+	t.elem = r.order.PushBack(t)
+	r.traces[id] = t
+	return t, flush
+}
+
+func (r *tailReporter) match(span *jaeger.Span) bool {
+	if r.cfg.Latency > 0 && span.Duration() >= r.cfg.Latency {
+		return true
+	}
+	if r.operations[span.OperationName()] {
+		return true
+	}
+
+	//X-Force-Trace、jaeger-debug-id 或强制采样的租户, 见 ForceSampling
+	if span.SpanContext().IsDebug() {
+		return true
+	}
+	tags := span.Tags()
+	if v, ok := tags[string(ext.SamplingPriority)].(uint16); ok && v > 0 {
+		return true
+	}
+	if v, ok := tags[string(ext.Error)].(bool); ok && v {
+		return true
+	}
+	if sc, ok := tags[tenant.TagKey].(string); ok && r.siteCodes[sc] {
+		return true
+	}
+	return false
+}
+
+// 需要持有锁
+func (r *tailReporter) remove(t *tailTrace) {
+	delete(r.traces, t.id)
+	r.order.Remove(t.elem)
+}
+
+// 记住决定, 超过 MaxDecisions 时忘记最早的; 需要持有锁
+func (r *tailReporter) decide(id jaeger.TraceID, keep bool, now time.Time) {
+	r.decided[id] = tailDecision{keep: keep, at: now}
+	r.expires.PushBack(decidedTrace{id: id, at: now})
+	for r.expires.Len() > r.cfg.MaxDecisions {
+		r.forget(r.expires.Front())
+	}
+}
+
+// 需要持有锁
+func (r *tailReporter) forget(e *list.Element) {
+	d := r.expires.Remove(e).(decidedTrace)
+	//同一个 trace 过期后可能再次决定, 只删除对应这一次的记录
+	if r.decided[d.id].at.Equal(d.at) {
+		delete(r.decided, d.id)
+	}
+}
+
+// 对已经从缓存中移除的 trace 做决定, 保留的交给 next 上报
+func (r *tailReporter) flush(traces []*tailTrace) {
+	if len(traces) == 0 {
+		return
+	}
+
+	now := time.Now()
+	keep := make([]bool, len(traces))
+	r.lock.Lock()
+	for i, t := range traces {
+		//只开始了 span, 一直没有上报(例如上游不采样), 不需要决定
+		if len(t.spans) == 0 {
+			continue
+		}
+		keep[i] = t.matched || (r.cfg.BaseRate > 0 && rand.Float64() < r.cfg.BaseRate)
+		r.decide(t.id, keep[i], now)
+		if keep[i] {
+			r.metrics.Kept.Inc(1)
+		} else {
+			r.metrics.Dropped.Inc(1)
+		}
+	}
+	r.metrics.Buffered.Update(int64(len(r.traces)))
+	r.lock.Unlock()
+
+	for i, t := range traces {
+		for _, s := range t.spans {
+			if keep[i] {
+				r.next.Report(s)
+			}
+			s.Release()
+		}
+	}
+}
+
+func (r *tailReporter) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.expire(time.Now())
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// 超过 DecisionWait 的 trace 直接做决定, 并清理过期的决定
+func (r *tailReporter) expire(now time.Time) {
+	var flush []*tailTrace
+
+	r.lock.Lock()
+	for e := r.order.Front(); e != nil; {
+		t := e.Value.(*tailTrace)
+		if now.Sub(t.start) < r.cfg.DecisionWait {
+			break
+		}
+		e = e.Next()
+		r.remove(t)
+		flush = append(flush, t)
+	}
+	for e := r.expires.Front(); e != nil && now.Sub(e.Value.(decidedTrace).at) >= r.cfg.DecisionWait; e = r.expires.Front() {
+		r.forget(e)
+	}
+	r.lock.Unlock()
+
+	r.flush(flush)
+}
+
+func (r *tailReporter) Close() {
+	close(r.stop)
+	<-r.done
+
+	r.lock.Lock()
+	var flush []*tailTrace
+	for e := r.order.Front(); e != nil; e = e.Next() {
+		flush = append(flush, e.Value.(*tailTrace))
+	}
+	r.traces = make(map[jaeger.TraceID]*tailTrace)
+	r.order.Init()
+	r.lock.Unlock()
+
+	r.flush(flush)
+	r.next.Close()
+}
@@ -0,0 +1,384 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/config"
+	"github.com/uber/jaeger-client-go/thrift-gen/sampling"
+	"github.com/uber/jaeger-lib/metrics"
+	jprom "github.com/uber/jaeger-lib/metrics/prometheus"
+)
+
+// 采样类型,前四种与 jaeger 一致,peroperation 为按操作名本地配置的采样
+const (
+	SamplerTypeConst         = jaeger.SamplerTypeConst
+	SamplerTypeProbabilistic = jaeger.SamplerTypeProbabilistic
+	SamplerTypeRateLimiting  = jaeger.SamplerTypeRateLimiting
+	SamplerTypeRemote        = jaeger.SamplerTypeRemote
+	SamplerTypePerOperation  = "peroperation"
+)
+
+// 非 jaeger 标准的环境变量
+const (
+	envSamplerOperations = "JAEGER_SAMPLER_OPERATIONS"  //按操作名的采样率, 例如: "/user/test=1,apiServer=0.1"
+	envSamplerLowerBound = "JAEGER_SAMPLER_LOWER_BOUND" //peroperation 每个操作每秒最少采样数
+	envSamplerSiteCodes  = "JAEGER_SAMPLER_SITECODES"   //全部采样的租户, 逗号分隔, 例如: "001,002"
+
+	envReporterFile        = "JAEGER_REPORTER_FILE"         //span 写入的本地文件
+	envReporterFileOnly    = "JAEGER_REPORTER_FILE_ONLY"    //true 时只写文件和内存
+	envReporterMemorySpans = "JAEGER_REPORTER_MEMORY_SPANS" //内存中保留的 span 数
+
+	envTracesExporter = "OTEL_TRACES_EXPORTER"        //otlp 时使用 OpenTelemetry 后端
+	envOTLPProtocol   = "OTEL_EXPORTER_OTLP_PROTOCOL" //grpc 或 http/protobuf
+	envPropagators    = "OTEL_PROPAGATORS"            //传播格式, 逗号分隔, 例如: "tracecontext,baggage,b3,jaeger"
+)
+
+// Tracer 配置
+type TracerConfig struct {
+	ServiceName string
+	Disabled    bool
+	Backend     string //jaeger(默认) 或 otlp
+	Sampler     SamplerConfig
+	Reporter    ReporterConfig
+	OTLP        OTLPConfig        //Backend 为 otlp 时有效
+	Tags        map[string]string //tracer 级别的 tag, 例如 version、env、pod
+	Metrics     metrics.Factory   //jaeger 内部指标, 为空时使用 TracerMetrics
+
+	Tail        TailSamplingConfig //尾部采样, 仅 jaeger 后端
+	Propagators []string           //传播格式, 见 PropagatorTraceContext 等, 为空时 jaeger 后端为 jaeger, otlp 后端为 tracecontext 和 baggage
+}
+
+// jaeger 内部指标(创建的 span、上报队列丢弃、发送 agent 失败、采样策略更新等), 以 jaeger_tracer_ 开头,
+// 注册在 prometheus.DefaultRegisterer 上, 与 grpc_prometheus 的指标一起暴露;
+// 同一个 registry 上重复注册会 panic, 多次创建 tracer 需要共用这一个 factory
+var TracerMetrics metrics.Factory = jprom.New()
+
+// 采样配置
+type SamplerConfig struct {
+	Type  string
+	Param float64 //const: 0/1, probabilistic: 采样率, ratelimiting: 每秒条数, peroperation: 默认采样率
+
+	//remote
+	SamplingServerURL       string
+	SamplingRefreshInterval time.Duration
+	MaxOperations           int
+
+	//peroperation
+	LowerBound float64            //每个操作每秒最少采样数
+	Operations map[string]float64 //操作名 -> 采样率
+
+	SiteCodes []string //全部采样的租户, 见 ForceSampling
+}
+
+// 上报配置, CollectorEndpoint 不为空时通过 HTTP 直接上报到 collector, 否则走 UDP agent
+type ReporterConfig struct {
+	LocalAgentHostPort  string
+	CollectorEndpoint   string
+	User                string
+	Password            string
+	QueueSize           int
+	BufferFlushInterval time.Duration
+	LogSpans            bool
+
+	File        FileConfig //Filename 不为空时把 span 以 JSON 行写入本地文件, 按大小和时间滚动
+	FileOnly    bool       //只写文件和内存, 不上报 agent/collector, 用于没有 Jaeger agent 的开发机和内网环境
+	MemorySpans int        //大于 0 时在内存中保留最近的 span, 通过 TraceHandler 查询
+}
+
+// 默认配置, 与原 NewJaegerTracer 行为一致
+func DefaultTracerConfig(serviceName string, agentHost string) *TracerConfig {
+	return &TracerConfig{
+		ServiceName: serviceName,
+		Sampler: SamplerConfig{
+			Type:  SamplerTypeRateLimiting,
+			Param: 10,
+		},
+		Reporter: ReporterConfig{
+			LocalAgentHostPort:  agentHost,
+			BufferFlushInterval: 1 * time.Second,
+		},
+	}
+}
+
+// 用 JAEGER_* 环境变量覆盖配置, 未设置的变量保留原值
+func (c *TracerConfig) FromEnv() (*TracerConfig, error) {
+	jc, err := c.jaegerConfig().FromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	c.ServiceName = jc.ServiceName
+	c.Disabled = jc.Disabled
+	if jc.Tags != nil {
+		c.Tags = make(map[string]string, len(jc.Tags))
+		for _, t := range jc.Tags {
+			c.Tags[t.Key] = fmt.Sprintf("%v", t.Value)
+		}
+	}
+
+	if os.Getenv("JAEGER_SAMPLER_TYPE") != "" {
+		c.Sampler.Type = jc.Sampler.Type
+	}
+	c.Sampler.Param = jc.Sampler.Param
+	c.Sampler.SamplingServerURL = jc.Sampler.SamplingServerURL
+	c.Sampler.SamplingRefreshInterval = jc.Sampler.SamplingRefreshInterval
+	c.Sampler.MaxOperations = jc.Sampler.MaxOperations
+
+	if e := os.Getenv(envSamplerLowerBound); e != "" {
+		value, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse env var %s=%s", envSamplerLowerBound, e)
+		}
+		c.Sampler.LowerBound = value
+	}
+	if e := os.Getenv(envSamplerOperations); e != "" {
+		ops, err := parseOperations(e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse env var %s=%s", envSamplerOperations, e)
+		}
+		c.Sampler.Operations = ops
+	}
+
+	if e := os.Getenv(envSamplerSiteCodes); e != "" {
+		c.Sampler.SiteCodes = strings.Split(e, ",")
+	}
+
+	c.Reporter.LocalAgentHostPort = jc.Reporter.LocalAgentHostPort
+	c.Reporter.CollectorEndpoint = jc.Reporter.CollectorEndpoint
+	c.Reporter.User = jc.Reporter.User
+	c.Reporter.Password = jc.Reporter.Password
+	c.Reporter.QueueSize = jc.Reporter.QueueSize
+	c.Reporter.BufferFlushInterval = jc.Reporter.BufferFlushInterval
+	c.Reporter.LogSpans = jc.Reporter.LogSpans
+	if e := os.Getenv(envReporterFile); e != "" {
+		c.Reporter.File.Filename = e
+	}
+	if e := os.Getenv(envReporterFileOnly); e != "" {
+		value, err := strconv.ParseBool(e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse env var %s=%s", envReporterFileOnly, e)
+		}
+		c.Reporter.FileOnly = value
+	}
+	if e := os.Getenv(envReporterMemorySpans); e != "" {
+		value, err := strconv.Atoi(e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse env var %s=%s", envReporterMemorySpans, e)
+		}
+		c.Reporter.MemorySpans = value
+	}
+
+	if e := os.Getenv(envTracesExporter); e != "" {
+		c.Backend = e
+	}
+	if e := os.Getenv(envOTLPProtocol); e != "" {
+		c.OTLP.Protocol = e
+	}
+	if e := os.Getenv(envPropagators); e != "" {
+		c.Propagators = nil
+		for _, p := range strings.Split(e, ",") {
+			if p = strings.TrimSpace(p); p != "" && p != "none" {
+				c.Propagators = append(c.Propagators, p)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// 根据配置初始化 tracer 并设置为全局 tracer
+func NewTracer(c *TracerConfig) (tracer opentracing.Tracer, closer io.Closer, err error) {
+	SetForcedSiteCodes(c.Sampler.SiteCodes...)
+	if c.Reporter.MemorySpans > 0 {
+		setSpanStore(newSpanStore(c.Reporter.MemorySpans))
+	} else {
+		setSpanStore(nil)
+	}
+
+	switch c.Backend {
+	case "", TracerBackendJaeger:
+	case TracerBackendOTLP:
+		if c.Disabled {
+			break
+		}
+		if c.Tail.Enabled {
+			return nil, nil, errors.New("tail sampling is only supported by the jaeger backend")
+		}
+		tracer, closer, err = newOTelTracer(c)
+		if err == nil {
+			tracer = tenantTracer{Tracer: tracer}
+			opentracing.SetGlobalTracer(tracer)
+		}
+		return tracer, closer, err
+	default:
+		return nil, nil, fmt.Errorf("unknown tracer backend %q", c.Backend)
+	}
+
+	factory := c.Metrics
+	if factory == nil {
+		factory = TracerMetrics
+	}
+	opts := []config.Option{
+		config.Logger(JaegerLogger()),
+		config.Metrics(factory),
+	}
+
+	if c.Sampler.Type == SamplerTypePerOperation {
+		opts = append(opts, config.Sampler(newPerOperationSampler(&c.Sampler)))
+	}
+
+	textMap, httpHeaders, err := c.jaegerPropagators(factory)
+	if err != nil {
+		return nil, nil, err
+	}
+	if textMap != nil {
+		opts = append(opts,
+			config.Injector(opentracing.TextMap, textMap),
+			config.Extractor(opentracing.TextMap, textMap),
+			config.Injector(opentracing.HTTPHeaders, httpHeaders),
+			config.Extractor(opentracing.HTTPHeaders, httpHeaders),
+		)
+	}
+
+	if c.Tail.Enabled {
+		//由尾部采样决定是否上报, 前置全部采样
+		opts = append(opts, config.Sampler(jaeger.NewConstSampler(true)))
+	}
+	if c.Reporter.File.Filename != "" || c.Reporter.MemorySpans > 0 || c.Tail.Enabled {
+		reporter, err := c.newJaegerReporter(factory)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, config.Reporter(reporter))
+		if tail, ok := reporter.(*tailReporter); ok {
+			//记录本进程创建的 span, 用于判断本进程的根 span
+			opts = append(opts, config.ContribObserver(tail))
+		}
+	}
+
+	tracer, closer, err = c.jaegerConfig().NewTracer(opts...)
+	if err == nil {
+		tracer = tenantTracer{Tracer: tracer}
+		opentracing.SetGlobalTracer(tracer)
+	}
+
+	return tracer, closer, err
+}
+
+// 本地文件、内存和 agent/collector 同时上报, 开启尾部采样时由它决定哪些 trace 交给这些 reporter
+func (c *TracerConfig) newJaegerReporter(factory metrics.Factory) (jaeger.Reporter, error) {
+	var reporters []jaeger.Reporter
+	if c.Reporter.File.Filename != "" {
+		reporters = append(reporters, newFileReporter(c.ServiceName, c.Reporter.File))
+	}
+	if store := getSpanStore(); store != nil {
+		reporters = append(reporters, &storeReporter{store: store, service: c.ServiceName})
+	}
+
+	if c.Reporter.FileOnly && c.Reporter.local() {
+		if c.Reporter.LogSpans {
+			reporters = append(reporters, jaeger.NewLoggingReporter(JaegerLogger()))
+		}
+	} else {
+		remote, err := c.jaegerConfig().Reporter.NewReporter(c.ServiceName, jaeger.NewMetrics(factory, nil), JaegerLogger())
+		if err != nil {
+			for _, r := range reporters {
+				r.Close()
+			}
+			return nil, err
+		}
+		reporters = append(reporters, remote)
+	}
+
+	reporter := jaeger.NewCompositeReporter(reporters...)
+	if c.Tail.Enabled {
+		reporter = newTailReporter(c.Tail, reporter, factory)
+	}
+	return reporter, nil
+}
+
+// 是否配置了本地文件或内存
+func (c *ReporterConfig) local() bool {
+	return c.File.Filename != "" || c.MemorySpans > 0
+}
+
+func (c *TracerConfig) jaegerConfig() *config.Configuration {
+	cfg := &config.Configuration{
+		ServiceName: c.ServiceName,
+		Disabled:    c.Disabled,
+		Sampler: &config.SamplerConfig{
+			Type:                    c.Sampler.Type,
+			Param:                   c.Sampler.Param,
+			SamplingServerURL:       c.Sampler.SamplingServerURL,
+			SamplingRefreshInterval: c.Sampler.SamplingRefreshInterval,
+			MaxOperations:           c.Sampler.MaxOperations,
+		},
+		Reporter: &config.ReporterConfig{
+			LogSpans:            c.Reporter.LogSpans,
+			QueueSize:           c.Reporter.QueueSize,
+			BufferFlushInterval: c.Reporter.BufferFlushInterval,
+			LocalAgentHostPort:  c.Reporter.LocalAgentHostPort,
+			CollectorEndpoint:   c.Reporter.CollectorEndpoint,
+			User:                c.Reporter.User,
+			Password:            c.Reporter.Password,
+		},
+	}
+
+	//peroperation 由 NewTracer 自行构造 sampler, 这里避免 jaeger 校验未知类型
+	if c.Sampler.Type == SamplerTypePerOperation {
+		cfg.Sampler.Type = ""
+	}
+
+	for k, v := range c.Tags {
+		cfg.Tags = append(cfg.Tags, opentracing.Tag{Key: k, Value: v})
+	}
+
+	return cfg
+}
+
+func newPerOperationSampler(c *SamplerConfig) jaeger.Sampler {
+	strategies := &sampling.PerOperationSamplingStrategies{
+		DefaultSamplingProbability:       c.Param,
+		DefaultLowerBoundTracesPerSecond: c.LowerBound,
+	}
+	for op, rate := range c.Operations {
+		strategies.PerOperationStrategies = append(strategies.PerOperationStrategies, &sampling.OperationSamplingStrategy{
+			Operation:             op,
+			ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{SamplingRate: rate},
+		})
+	}
+
+	return jaeger.NewPerOperationSampler(jaeger.PerOperationSamplerParams{
+		MaxOperations: c.MaxOperations,
+		Strategies:    strategies,
+	})
+}
+
+// 解析 "op1=0.5,op2=1"
+func parseOperations(s string) (map[string]float64, error) {
+	ops := make(map[string]float64)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		idx := strings.LastIndex(item, "=")
+		if idx < 1 {
+			return nil, fmt.Errorf("invalid operation %q", item)
+		}
+		rate, err := strconv.ParseFloat(item[idx+1:], 64)
+		if err != nil {
+			return nil, err
+		}
+		ops[strings.TrimSpace(item[:idx])] = rate
+	}
+	return ops, nil
+}
@@ -3,10 +3,15 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 	"tracedemo/apiserver"
 	"tracedemo/db"
 	"tracedemo/grpcserver"
 	"tracedemo/logger"
+	"tracedemo/redact"
+	"tracedemo/tenant"
+
+	"google.golang.org/grpc/grpclog"
 )
 
 func main() {
@@ -14,7 +19,62 @@ func main() {
 	jaegerHost := "192.168.100.30:6831"
 	serverName, _ := os.Hostname()
 	serverName = "trace-" + serverName
-	_, _, err := logger.NewJaegerTracer(serverName, jaegerHost)
+	logConfig := logger.Config{
+		Encoding:    os.Getenv("LOG_ENCODING"),
+		Level:       os.Getenv("LOG_LEVEL"),
+		SpanLog:     true,
+		ServiceName: serverName,
+		Version:     os.Getenv("SERVICE_VERSION"),
+		Env:         os.Getenv("SERVICE_ENV"),
+	}
+	//debug 日志只在 trace 被采样时输出
+	logConfig.DebugSampledOnly = os.Getenv("LOG_DEBUG_SAMPLED_ONLY") == "true"
+	//相同日志去重和限流, 故障时防止同一条错误日志刷满磁盘
+	logConfig.Sampling.Enabled = os.Getenv("LOG_SAMPLING") == "true"
+	//VM 部署时同时写文件
+	if logFile := os.Getenv("LOG_FILE"); logFile != "" {
+		logConfig.Sinks = []logger.SinkConfig{
+			{Type: logger.SinkStderr},
+			{Type: logger.SinkFile, File: logger.FileConfig{
+				Filename:       logFile,
+				MaxSize:        100,
+				MaxAge:         7,
+				MaxBackups:     10,
+				Compress:       true,
+				RotateInterval: 24 * time.Hour,
+			}},
+		}
+	}
+	//没有日志采集 sidecar 的主机自己投递日志, LOG_SHIP_FORMAT 为 loki 或 elasticsearch, 为空时按 URL 判断
+	if shipURL := os.Getenv("LOG_SHIP_URL"); shipURL != "" {
+		if len(logConfig.Sinks) == 0 {
+			logConfig.Sinks = []logger.SinkConfig{{Type: logger.SinkStderr}}
+		}
+		logConfig.Sinks = append(logConfig.Sinks, logger.SinkConfig{Type: logger.SinkHTTP, HTTP: logger.ShipConfig{
+			URL:    shipURL,
+			Format: os.Getenv("LOG_SHIP_FORMAT"),
+			Index:  os.Getenv("LOG_SHIP_INDEX"),
+		}})
+	}
+	err := logger.Init(logConfig)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("初始化日志错误%v", err))
+	}
+	//grpc 内部日志
+	grpclog.SetLoggerV2(logger.GRPCLogger())
+
+	//日志脱敏
+	err = redact.Init(redact.DefaultConfig())
+	if err != nil {
+		fmt.Println(fmt.Sprintf("初始化脱敏规则错误%v", err))
+	}
+
+	tracerConfig, err := logger.DefaultTracerConfig(serverName, jaegerHost).FromEnv()
+	if err != nil {
+		fmt.Println(fmt.Sprintf("读取JaegerTracer环境变量错误%v", err))
+		tracerConfig = logger.DefaultTracerConfig(serverName, jaegerHost)
+	}
+	_, _, err = logger.NewTracer(tracerConfig)
 	if err != nil {
 		fmt.Println(fmt.Sprintf("初始化JaegerTracer错误%v", err))
 	}
@@ -34,10 +94,32 @@ func main() {
 	}
 
 	//启动api
-	go apiserver.StartApiServerr()
+	go apiserver.StartApiServerr(newTenantChain("API_TENANT_"))
+
+	//启动管理接口, 默认只监听本机
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = "127.0.0.1:8081"
+	}
+	go apiserver.StartAdminServer(adminAddr)
 
 	//启动GRPC
-	go grpcserver.StartGrpcServer()
+	go grpcserver.StartGrpcServer(newTenantChain("GRPC_TENANT_"))
 
 	select {}
 }
+
+// 按 <prefix>RESOLVERS 等环境变量创建租户解析规则;
+// 出错时直接退出, 不能退回默认规则, 否则严格模式会失效, 请求都写到 001 的库
+func newTenantChain(prefix string) *tenant.Chain {
+	tenantConfig, err := tenant.DefaultConfig().FromEnv(prefix)
+	if err == nil {
+		var chain *tenant.Chain
+		if chain, err = tenant.NewChain(tenantConfig); err == nil {
+			return chain
+		}
+	}
+	fmt.Println(fmt.Sprintf("初始化租户解析错误%v", err))
+	os.Exit(1)
+	return nil
+}
@@ -2,16 +2,18 @@ package middleware
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"runtime/debug"
-	"strings"
 	"time"
 	"tracedemo/logger"
+	"tracedemo/redact"
+	"tracedemo/tenant"
 )
 
 type MDCarrier struct {
@@ -68,11 +70,7 @@ func ClientTracing(tracer opentracing.Tracer) grpc.UnaryClientInterceptor {
 		}
 
 		///SiteCode
-		siteCode := fmt.Sprintf("%v", ctx.Value("SiteCode"))
-		if len(siteCode) < 1 || strings.Contains(siteCode, "nil") {
-			siteCode = "001"
-		}
-		md.Set("SiteCode", siteCode)
+		md.Set(tenant.Header, outgoingSiteCode(ctx))
 		//
 		newCtx := metadata.NewOutgoingContext(ctx, md)
 		err = invoker(newCtx, method, request, reply, cc, opts...)
@@ -94,13 +92,9 @@ func ClientSiteCode() grpc.UnaryClientInterceptor {
 		}
 
 		///SiteCode
-		siteCode := fmt.Sprintf("%v", ctx.Value("SiteCode"))
-		if len(siteCode) < 1 || strings.Contains(siteCode, "nil") {
-			siteCode = "001"
-		}
-		md.Set("SiteCode", siteCode)
+		md.Set(tenant.Header, outgoingSiteCode(ctx))
 
-		return invoker(ctx, method, request, reply, cc, opts...)
+		return invoker(metadata.NewOutgoingContext(ctx, md), method, request, reply, cc, opts...)
 	}
 }
 
@@ -116,11 +110,11 @@ func ClientTimeLog() grpc.UnaryClientInterceptor {
 		startTime := time.Now().UnixNano()
 		err := invoker(ctx, method, request, reply, cc, opts...)
 		duration := (time.Now().UnixNano() - startTime) / 1e6
-		requestByte, _ := json.Marshal(request)
-		responseByte, _ := json.Marshal(reply)
-		logger.Info(ctx, fmt.Sprintf("grpc-client:方法名:%v,耗时:%vms,请求数据:%v,返回数据:%v", method, duration, string(requestByte), string(responseByte)))
+		requestStr := redact.JSON(request)
+		responseStr := redact.JSON(reply)
+		logger.Info(ctx, fmt.Sprintf("grpc-client:方法名:%v,耗时:%vms,请求数据:%v,返回数据:%v", method, duration, requestStr, responseStr))
 		if err != nil {
-			logger.Error(ctx, fmt.Sprintf("grpc-client:方法名:%v,耗时:%vms,请求数据:%v,返回错误:%v", method, duration, string(requestByte), err))
+			logger.Error(ctx, fmt.Sprintf("grpc-client:方法名:%v,耗时:%vms,请求数据:%v,返回错误:%v", method, duration, requestStr, err))
 		}
 
 		return err
@@ -143,12 +137,16 @@ func ServerTracing(tracer opentracing.Tracer) grpc.UnaryServerInterceptor {
 		if err != nil && err != opentracing.ErrSpanContextNotFound {
 			logger.Error(ctx, "ServerInterceptor extract from metadata err: %v", err)
 		} else {
-			span := tracer.StartSpan(
-				info.FullMethod,
+			opts := []opentracing.StartSpanOption{
 				ext.RPCServerOption(spanContext),
 				opentracing.Tag{Key: string(ext.Component), Value: "(gRPC Server)"},
 				ext.SpanKindRPCServer,
-			)
+			}
+			//X-Force-Trace、jaeger-debug-id 或需要全部采样的租户
+			if logger.ForceSampling(mdHeader(md)) {
+				opts = append(opts, logger.ForceSamplingOption())
+			}
+			span := tracer.StartSpan(info.FullMethod, opts...)
 			defer span.Finish()
 
 			ctx = opentracing.ContextWithSpan(ctx, span)
@@ -159,28 +157,19 @@ func ServerTracing(tracer opentracing.Tracer) grpc.UnaryServerInterceptor {
 
 }
 
+// 读取 SiteCode metadata, 没有时为默认租户
 func ServerSiteCode() grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (_ interface{}, err error) {
-		//读取siteCode
-		incomingContext, ok := metadata.FromIncomingContext(ctx)
-		siteCode := ""
-		if ok {
-			siteCodeArr := incomingContext.Get("SiteCode")
-			if siteCodeArr != nil && len(siteCodeArr) > 0 {
-				siteCode = siteCodeArr[0]
-			}
-		} else {
-			incomingContext = metadata.New(nil)
-		}
+	return ServerTenant(tenant.DefaultChain())
+}
 
-		if len(siteCode) < 1 {
-			siteCode = "001"
+// 按 chain 解析租户并设置到上下文, 严格模式下解析失败返回 InvalidArgument
+func ServerTenant(chain *tenant.Chain) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (_ interface{}, err error) {
+		ctx, err = resolveTenant(ctx, chain, info.FullMethod)
+		if err != nil {
+			return nil, err
 		}
-
-		//设置siteCode到上下文
-		c2 := context.WithValue(ctx, "001", siteCode)
-
-		return handler(c2, req)
+		return handler(ctx, req)
 	}
 }
 
@@ -196,18 +185,46 @@ func ServerTimeLog() grpc.UnaryServerInterceptor {
 		startTime := time.Now().UnixNano()
 		ret, err := handler(ctx, req)
 		duration := (time.Now().UnixNano() - startTime) / 1e6
-		requestByte, _ := json.Marshal(req)
+		requestStr := redact.JSON(req)
 		responseStr := ""
 		if err == nil {
-			responseByte, _ := json.Marshal(ret)
-			responseStr = string(responseByte)
+			responseStr = redact.JSON(ret)
 		}
 
-		logger.Info(ctx, fmt.Sprintf("grpc-server:方法名:%v,耗时:%vms,请求数据:%v,返回数据:%v", info.FullMethod, duration, string(requestByte), responseStr))
+		logger.Info(ctx, fmt.Sprintf("grpc-server:方法名:%v,耗时:%vms,请求数据:%v,返回数据:%v", info.FullMethod, duration, requestStr, responseStr))
 		if err != nil {
-			logger.Error(ctx, fmt.Sprintf("grpc-server:方法名:%v,耗时:%vms,请求数据:%v,返回错误:%v", info.FullMethod, duration, string(requestByte), err))
+			logger.Error(ctx, fmt.Sprintf("grpc-server:方法名:%v,耗时:%vms,请求数据:%v,返回错误:%v", info.FullMethod, duration, requestStr, err))
 		}
 
 		return ret, err
 	}
 }
+
+// 发给下游的 SiteCode, 上下文中没有时为默认租户
+func outgoingSiteCode(ctx context.Context) string {
+	if siteCode, ok := tenant.TenantFrom(ctx); ok {
+		return siteCode
+	}
+	return tenant.Default
+}
+
+// 从 metadata 中解析租户, 设置到上下文
+func resolveTenant(ctx context.Context, chain *tenant.Chain, method string) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		md = metadata.New(nil)
+	}
+	siteCode, err := chain.Resolve(&tenant.Request{
+		Metadata: mdHeader(md),
+		Baggage:  tenant.Baggage(ctx),
+		Host:     mdHeader(md)(":authority"),
+		Path:     method,
+	})
+	if err != nil {
+		logger.Warn(ctx, "解析租户错误, 方法名:%v, %v", method, err)
+		return ctx, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ctx = tenant.WithTenant(ctx, siteCode)
+	logger.ForceSamplingSiteCode(ctx, siteCode)
+	return ctx, nil
+}
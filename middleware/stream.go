@@ -0,0 +1,334 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tracedemo/logger"
+	"tracedemo/tenant"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 流式 RPC 的拦截器, 与 unary 版本的行为一致:
+// 每个流一个 span, 每条消息记录一个 span 事件, 传递 SiteCode, 结束时输出耗时和消息数, 捕获 panic
+
+// 包装 grpc.ClientStream, 统计消息数, 流结束(RecvMsg 返回 EOF 或错误、ctx 结束)时回调一次 onFinish;
+// 客户端流(服务端只返回一条消息)由 CloseAndRecv 结束, 不会读到 EOF, 收到第一条消息即结束
+type clientStream struct {
+	grpc.ClientStream
+	desc     *grpc.StreamDesc
+	onMsg    func(event string, n int64)
+	onFinish func(err error, sent, received int64)
+
+	sent     int64
+	received int64
+	once     sync.Once
+	done     chan struct{}
+}
+
+func newClientStream(ctx context.Context, desc *grpc.StreamDesc, stream grpc.ClientStream, onMsg func(event string, n int64), onFinish func(err error, sent, received int64)) *clientStream {
+	s := &clientStream{ClientStream: stream, desc: desc, onMsg: onMsg, onFinish: onFinish, done: make(chan struct{})}
+	//调用方没有读到 EOF 就放弃了流, 以 ctx 结束为准
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.finish(ctx.Err())
+		case <-s.done:
+		}
+	}()
+	return s
+}
+
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		close(s.done)
+		s.onFinish(err, atomic.LoadInt64(&s.sent), atomic.LoadInt64(&s.received))
+	})
+}
+
+func (s *clientStream) Header() (metadata.MD, error) {
+	md, err := s.ClientStream.Header()
+	if err != nil {
+		s.finish(err)
+	}
+	return md, err
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+	n := atomic.AddInt64(&s.sent, 1)
+	if s.onMsg != nil {
+		s.onMsg("message.sent", n)
+	}
+	return nil
+}
+
+func (s *clientStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	if err != nil {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		s.finish(nil)
+		return err
+	}
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+	n := atomic.AddInt64(&s.received, 1)
+	if s.onMsg != nil {
+		s.onMsg("message.received", n)
+	}
+	if !s.desc.ServerStreams {
+		s.finish(nil)
+	}
+	return nil
+}
+
+// 包装 grpc.ServerStream, 替换 Context 并统计消息数
+type serverStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	onMsg func(event string, n int64)
+
+	sent     int64
+	received int64
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err != nil {
+		return err
+	}
+	n := atomic.AddInt64(&s.sent, 1)
+	if s.onMsg != nil {
+		s.onMsg("message.sent", n)
+	}
+	return nil
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	n := atomic.AddInt64(&s.received, 1)
+	if s.onMsg != nil {
+		s.onMsg("message.received", n)
+	}
+	return nil
+}
+
+func wrapServerStream(ss grpc.ServerStream, ctx context.Context) *serverStream {
+	return &serverStream{ServerStream: ss, ctx: ctx}
+}
+
+func spanMessageEvent(span opentracing.Span) func(event string, n int64) {
+	return func(event string, n int64) {
+		span.LogKV("event", event, "message.id", n)
+	}
+}
+
+// 流式客户端的 tracing, 流结束时 finish span
+func ClientStreamTracing(tracer opentracing.Tracer) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		var parentCtx opentracing.SpanContext
+		if parentSpan := opentracing.SpanFromContext(ctx); parentSpan != nil {
+			parentCtx = parentSpan.Context()
+		}
+		span := tracer.StartSpan(
+			method,
+			opentracing.ChildOf(parentCtx),
+			opentracing.Tag{Key: string(ext.Component), Value: "gRPC Client"},
+			ext.SpanKindRPCClient,
+		)
+		spanCtx := opentracing.ContextWithSpan(ctx, span)
+
+		md, ok := metadata.FromOutgoingContext(ctx)
+		if !ok {
+			md = metadata.New(nil)
+		} else {
+			md = md.Copy()
+		}
+		if err := tracer.Inject(span.Context(), opentracing.TextMap, MDCarrier{md}); err != nil {
+			logger.Error(ctx, "ClientStreamTracing inject span error :%v", err.Error())
+		}
+
+		///SiteCode
+		md.Set(tenant.Header, outgoingSiteCode(ctx))
+
+		stream, err := streamer(metadata.NewOutgoingContext(ctx, md), desc, cc, method, opts...)
+		if err != nil {
+			logger.Error(spanCtx, "ClientStreamTracing call error : %v", err.Error())
+			span.Finish()
+			return nil, err
+		}
+
+		return newClientStream(ctx, desc, stream, spanMessageEvent(span), func(err error, sent, received int64) {
+			span.SetTag("message.sent", sent)
+			span.SetTag("message.received", received)
+			if err != nil {
+				logger.Error(spanCtx, "ClientStreamTracing stream error : %v", err.Error())
+			}
+			span.Finish()
+		}), nil
+	}
+}
+
+func ClientStreamSiteCode() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, ok := metadata.FromOutgoingContext(ctx)
+		if !ok {
+			md = metadata.New(nil)
+		} else {
+			md = md.Copy()
+		}
+
+		///SiteCode
+		md.Set(tenant.Header, outgoingSiteCode(ctx))
+
+		return streamer(metadata.NewOutgoingContext(ctx, md), desc, cc, method, opts...)
+	}
+}
+
+func ClientStreamTimeLog() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (_ grpc.ClientStream, err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				stack := debug.Stack()
+				logger.Error(ctx, fmt.Sprintf("grpc-client-stream has err:%v, stack:%v", e, string(stack)))
+				err = status.Errorf(codes.Internal, "panic: %v", e)
+			}
+		}()
+
+		startTime := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			logger.Error(ctx, fmt.Sprintf("grpc-client-stream:方法名:%v,耗时:%vms,返回错误:%v", method, time.Since(startTime).Milliseconds(), err))
+			return nil, err
+		}
+
+		return newClientStream(ctx, desc, stream, nil, func(err error, sent, received int64) {
+			duration := time.Since(startTime).Milliseconds()
+			logger.Info(ctx, fmt.Sprintf("grpc-client-stream:方法名:%v,耗时:%vms,发送消息数:%v,接收消息数:%v", method, duration, sent, received))
+			if err != nil {
+				logger.Error(ctx, fmt.Sprintf("grpc-client-stream:方法名:%v,耗时:%vms,发送消息数:%v,接收消息数:%v,返回错误:%v", method, duration, sent, received, err))
+			}
+		}), nil
+	}
+}
+
+// 流式服务端的 tracing, handler 返回时 finish span
+func ServerStreamTracing(tracer opentracing.Tracer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			md = metadata.New(nil)
+		}
+
+		spanContext, err := tracer.Extract(opentracing.TextMap, MDCarrier{md})
+		if err != nil && err != opentracing.ErrSpanContextNotFound {
+			logger.Error(ctx, "ServerStreamTracing extract from metadata err: %v", err)
+			return handler(srv, ss)
+		}
+
+		opts := []opentracing.StartSpanOption{
+			ext.RPCServerOption(spanContext),
+			opentracing.Tag{Key: string(ext.Component), Value: "(gRPC Server)"},
+			ext.SpanKindRPCServer,
+		}
+		if logger.ForceSampling(mdHeader(md)) {
+			opts = append(opts, logger.ForceSamplingOption())
+		}
+		span := tracer.StartSpan(info.FullMethod, opts...)
+		defer span.Finish()
+
+		stream := wrapServerStream(ss, opentracing.ContextWithSpan(ctx, span))
+		stream.onMsg = spanMessageEvent(span)
+		err = handler(srv, stream)
+
+		span.SetTag("message.sent", atomic.LoadInt64(&stream.sent))
+		span.SetTag("message.received", atomic.LoadInt64(&stream.received))
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.LogKV("event", "error", "message", err.Error())
+		}
+		return err
+	}
+}
+
+// 读取 SiteCode metadata, 没有时为默认租户
+func ServerStreamSiteCode() grpc.StreamServerInterceptor {
+	return ServerStreamTenant(tenant.DefaultChain())
+}
+
+// 按 chain 解析租户并设置到上下文, 严格模式下解析失败返回 InvalidArgument
+func ServerStreamTenant(chain *tenant.Chain) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := resolveTenant(ss.Context(), chain, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, wrapServerStream(ss, ctx))
+	}
+}
+
+func ServerStreamTimeLog() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		ctx := ss.Context()
+		stream := wrapServerStream(ss, ctx)
+		startTime := time.Now()
+
+		defer func() {
+			if e := recover(); e != nil {
+				stack := debug.Stack()
+				logger.Error(ctx, fmt.Sprintf("grpc-server-stream has err:%v, stack:%v", e, string(stack)))
+				err = status.Errorf(codes.Internal, "panic: %v", e)
+			}
+
+			duration := time.Since(startTime).Milliseconds()
+			sent, received := atomic.LoadInt64(&stream.sent), atomic.LoadInt64(&stream.received)
+			logger.Info(ctx, fmt.Sprintf("grpc-server-stream:方法名:%v,耗时:%vms,发送消息数:%v,接收消息数:%v", info.FullMethod, duration, sent, received))
+			if err != nil {
+				logger.Error(ctx, fmt.Sprintf("grpc-server-stream:方法名:%v,耗时:%vms,发送消息数:%v,接收消息数:%v,返回错误:%v", info.FullMethod, duration, sent, received, err))
+			}
+		}()
+
+		return handler(srv, stream)
+	}
+}
+
+// 按名称读取 metadata, 用于 logger.ForceSampling
+func mdHeader(md metadata.MD) func(key string) string {
+	return func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+}
@@ -0,0 +1,157 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// proto 字段选项 (redact.sensitive) 的编号, 定义见 redact/options.proto
+const SensitiveOptionNumber protowire.Number = 50101
+
+// 序列化为 JSON 并脱敏: 敏感字段名和带 (redact.sensitive) 选项的 proto 字段替换为 Mask, 字符串按正则替换
+func JSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+
+	var extra map[string]bool
+	if m, ok := v.(proto.Message); ok {
+		extra = sensitiveProtoFields(m.ProtoReflect().Descriptor())
+	}
+	return string(redactJSON(b, extra))
+}
+
+// 对任意值脱敏, 返回 JSON 解码后的 map、slice 等通用结构, 无法序列化时原样返回
+func Interface(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var data interface{}
+	if err := dec.Decode(&data); err != nil {
+		return v
+	}
+	return redactValue(data, nil)
+}
+
+func redactJSON(b []byte, extra map[string]bool) []byte {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var data interface{}
+	if err := dec.Decode(&data); err != nil {
+		return []byte(String(string(b)))
+	}
+
+	ret, err := json.Marshal(redactValue(data, extra))
+	if err != nil {
+		return []byte(String(string(b)))
+	}
+	return ret
+}
+
+func redactValue(v interface{}, extra map[string]bool) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if extra[k] || IsSensitiveField(k) {
+				t[k] = Mask()
+				continue
+			}
+			t[k] = redactValue(val, extra)
+		}
+		return t
+	case []interface{}:
+		for i, val := range t {
+			t[i] = redactValue(val, extra)
+		}
+		return t
+	case string:
+		return String(t)
+	}
+	return v
+}
+
+var protoFieldCache sync.Map //protoreflect.FullName -> map[string]bool
+
+// 带 (redact.sensitive) 选项的字段名, 包括嵌套 message 中的字段
+func sensitiveProtoFields(md protoreflect.MessageDescriptor) map[string]bool {
+	if cached, ok := protoFieldCache.Load(md.FullName()); ok {
+		return cached.(map[string]bool)
+	}
+
+	fields := make(map[string]bool)
+	collectProtoFields(md, fields, make(map[protoreflect.FullName]bool))
+	protoFieldCache.Store(md.FullName(), fields)
+	return fields
+}
+
+func collectProtoFields(md protoreflect.MessageDescriptor, fields map[string]bool, seen map[protoreflect.FullName]bool) {
+	if seen[md.FullName()] {
+		return
+	}
+	seen[md.FullName()] = true
+
+	fds := md.Fields()
+	for i := 0; i < fds.Len(); i++ {
+		fd := fds.Get(i)
+		if isSensitiveOption(fd) {
+			//encoding/json 使用 proto 字段名, protojson 使用 json 名
+			fields[string(fd.Name())] = true
+			fields[fd.JSONName()] = true
+		}
+		if fd.Message() != nil {
+			collectProtoFields(fd.Message(), fields, seen)
+		}
+	}
+}
+
+// 选项未注册到 protoregistry 时保存在 unknown fields 中, 按编号读取
+func isSensitiveOption(fd protoreflect.FieldDescriptor) bool {
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return false
+	}
+
+	//生成了 options.proto 的 Go 代码时, 选项会被解析为扩展字段
+	sensitive := false
+	opts.ProtoReflect().Range(func(f protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if f.IsExtension() && f.Number() == SensitiveOptionNumber {
+			sensitive = v.Bool()
+			return false
+		}
+		return true
+	})
+	if sensitive {
+		return true
+	}
+
+	b := opts.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return false
+		}
+		b = b[n:]
+		if num == SensitiveOptionNumber && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			return n > 0 && v != 0
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return false
+		}
+		b = b[n:]
+	}
+	return false
+}
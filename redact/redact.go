@@ -0,0 +1,153 @@
+package redact
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// 脱敏配置, 日志、gRPC 请求/响应日志、SQL 日志共用同一份规则
+type Config struct {
+	Fields   []string  //敏感字段名, 不区分大小写, 忽略 _ 和 -, 例如 password 同时匹配 Password、pass_word
+	Columns  []string  //敏感的数据库列名, 规则同 Fields, SQL 日志中这些列的值会被替换
+	Patterns []Pattern //按正则替换文本中的敏感信息
+	Mask     string    //字段和列的替换值, 默认 ******
+}
+
+// 正则规则, Replace 支持 $1 这类分组引用, 为空时整段替换为 Mask
+type Pattern struct {
+	Name    string
+	Regexp  string
+	Replace string
+}
+
+const defaultMask = "******"
+
+// 常用的规则: 手机号、身份证、邮箱、Bearer token
+var DefaultPatterns = []Pattern{
+	{Name: "phone", Regexp: `\b(1[3-9]\d)\d{4}(\d{4})\b`, Replace: "$1****$2"},
+	{Name: "idCard", Regexp: `\b(\d{6})\d{8}(\d{3}[\dXx])\b`, Replace: "$1********$2"},
+	{Name: "email", Regexp: `\b([\w.+-])[\w.+-]*@([\w-]+\.[\w.-]+)\b`, Replace: "$1***@$2"},
+	{Name: "token", Regexp: `(?i)\b(bearer\s+)[\w\-.~+/]+=*`, Replace: "$1******"},
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Fields:   []string{"password", "passwd", "pwd", "secret", "token", "accessToken", "refreshToken", "authorization", "idCard", "bankCard"},
+		Columns:  []string{"password", "passwd", "id_card", "bank_card", "phone", "mobile"},
+		Patterns: DefaultPatterns,
+	}
+}
+
+type pattern struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+type rules struct {
+	fields   map[string]bool
+	columns  map[string]bool
+	patterns []pattern
+	mask     string
+}
+
+var (
+	current  = &rules{mask: defaultMask}
+	ruleLock sync.RWMutex
+)
+
+// 设置脱敏规则, 在程序启动时调用
+func Init(c Config) error {
+	r := &rules{
+		fields:  normalizeSet(c.Fields),
+		columns: normalizeSet(c.Columns),
+		mask:    c.Mask,
+	}
+	if r.mask == "" {
+		r.mask = defaultMask
+	}
+	for _, p := range c.Patterns {
+		re, err := regexp.Compile(p.Regexp)
+		if err != nil {
+			return errors.Wrapf(err, "invalid redact pattern %s", p.Name)
+		}
+		replace := p.Replace
+		if replace == "" {
+			replace = r.mask
+		}
+		r.patterns = append(r.patterns, pattern{re: re, replace: replace})
+	}
+
+	ruleLock.Lock()
+	current = r
+	ruleLock.Unlock()
+	return nil
+}
+
+func getRules() *rules {
+	ruleLock.RLock()
+	defer ruleLock.RUnlock()
+	return current
+}
+
+// 替换值
+func Mask() string {
+	return getRules().mask
+}
+
+// 字段名是否敏感
+func IsSensitiveField(name string) bool {
+	r := getRules()
+	return len(r.fields) > 0 && r.fields[normalize(name)]
+}
+
+// 列名是否敏感, 支持 table.column 和带反引号的写法
+func IsSensitiveColumn(name string) bool {
+	r := getRules()
+	if len(r.columns) == 0 {
+		return false
+	}
+	name = strings.Trim(name, "`\"")
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = strings.Trim(name[idx+1:], "`\"")
+	}
+	return r.columns[normalize(name)]
+}
+
+// 按正则规则替换文本中的敏感信息
+func String(s string) string {
+	r := getRules()
+	for _, p := range r.patterns {
+		s = p.re.ReplaceAllString(s, p.replace)
+	}
+	return s
+}
+
+// 字段名敏感时返回 Mask, 否则字符串按正则替换, 其他类型原样返回
+func Value(name string, v interface{}) interface{} {
+	if IsSensitiveField(name) {
+		return Mask()
+	}
+	if s, ok := v.(string); ok {
+		return String(s)
+	}
+	return v
+}
+
+func normalizeSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[normalize(n)] = true
+	}
+	return set
+}
+
+func normalize(name string) string {
+	name = strings.ToLower(name)
+	if strings.ContainsAny(name, "_-") {
+		name = strings.NewReplacer("_", "", "-", "").Replace(name)
+	}
+	return name
+}
@@ -0,0 +1,113 @@
+package redact
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	sqlValue = `('(?:[^'\\]|\\.|'')*'|-?[\d.]+|\((?:[^()']|'(?:[^'\\]|\\.|'')*')*\))`
+
+	//col = value, col IN (...), col LIKE '...'
+	sqlCompareRegexp = regexp.MustCompile("([`\\w.]+)(\\s*(?:=|<>|!=|<=|>=|<|>)\\s*|\\s+(?i:like|in)\\s+)" + sqlValue)
+	sqlInsertRegexp  = regexp.MustCompile(`(?is)^(\s*(?:insert|replace)\s+(?:ignore\s+)?into\s+\S+\s*\(([^)]*)\)\s*values\s*)(.*)$`)
+)
+
+// 对已经填充了参数的 SQL 脱敏: 敏感列的值替换为 Mask, 其余文本按正则替换
+func SQL(sql string) string {
+	r := getRules()
+	if len(r.columns) > 0 {
+		sql = redactInsert(sql)
+		sql = sqlCompareRegexp.ReplaceAllStringFunc(sql, func(m string) string {
+			sub := sqlCompareRegexp.FindStringSubmatch(m)
+			if !IsSensitiveColumn(sub[1]) {
+				return m
+			}
+			if strings.HasPrefix(sub[3], "(") {
+				return sub[1] + sub[2] + "('" + r.mask + "')"
+			}
+			return sub[1] + sub[2] + "'" + r.mask + "'"
+		})
+	}
+	return String(sql)
+}
+
+// INSERT INTO t (a,b) VALUES (1,'x'),(2,'y') 按列的位置替换
+func redactInsert(sql string) string {
+	m := sqlInsertRegexp.FindStringSubmatch(sql)
+	if m == nil {
+		return sql
+	}
+
+	columns := strings.Split(m[2], ",")
+	sensitive := make([]bool, len(columns))
+	found := false
+	for i, c := range columns {
+		sensitive[i] = IsSensitiveColumn(strings.TrimSpace(c))
+		found = found || sensitive[i]
+	}
+	if !found {
+		return sql
+	}
+
+	return m[1] + redactValues(m[3], sensitive)
+}
+
+// 扫描 VALUES 后面的内容, 替换每个元组中敏感位置的值
+func redactValues(values string, sensitive []bool) string {
+	var (
+		out     strings.Builder
+		depth   int
+		index   int
+		start   int
+		inQuote bool
+		last    int //已写入 out 的位置
+	)
+
+	endValue := func(end int) {
+		if index < len(sensitive) && sensitive[index] {
+			out.WriteString(values[last:start])
+			out.WriteString("'" + Mask() + "'")
+			last = end
+		}
+		index++
+	}
+
+	for i := 0; i < len(values); i++ {
+		c := values[i]
+		if inQuote {
+			switch {
+			case c == '\\':
+				i++
+			case c == '\'' && i+1 < len(values) && values[i+1] == '\'':
+				i++
+			case c == '\'':
+				inQuote = false
+			}
+			continue
+		}
+
+		switch c {
+		case '\'':
+			inQuote = true
+		case '(':
+			depth++
+			if depth == 1 {
+				index, start = 0, i+1
+			}
+		case ',':
+			if depth == 1 {
+				endValue(i)
+				start = i + 1
+			}
+		case ')':
+			if depth == 1 {
+				endValue(i)
+			}
+			depth--
+		}
+	}
+
+	out.WriteString(values[last:])
+	return out.String()
+}
@@ -0,0 +1,305 @@
+package tenant
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// 解析方式
+const (
+	ResolverHeader    = "header"    //HTTP 请求头
+	ResolverMetadata  = "metadata"  //gRPC metadata
+	ResolverBaggage   = "baggage"   //上游 span 的 baggage, 中间经过没有使用本项目拦截器的服务时也能传递
+	ResolverSubdomain = "subdomain" //域名的第一段, 例如 vip.example.com
+	ResolverPath      = "path"      //URL 路径前缀后的第一段, 例如 /site/vip/user/test
+	ResolverJWT       = "jwt"       //Authorization: Bearer <jwt> 中的 claim
+	ResolverDefault   = "default"   //固定的租户
+)
+
+var (
+	ErrNoTenant      = errors.New("tenant is required")
+	ErrUnknownTenant = errors.New("unknown tenant")
+)
+
+// 解析租户需要的请求信息, HTTP 和 gRPC 各自填充
+type Request struct {
+	Header   func(key string) string //HTTP 请求头, gRPC 时为空
+	Metadata func(key string) string //gRPC metadata, HTTP 时为空
+	Baggage  func(key string) string //当前 span 的 baggage, 见 Baggage
+	Host     string                  //HTTP 的 Host, gRPC 的 :authority
+	Path     string                  //HTTP 的 URL 路径, gRPC 的方法全名
+}
+
+// 从请求中解析租户, 返回空字符串时交给下一个
+type Resolver interface {
+	Resolve(r *Request) string
+}
+
+type ResolverFunc func(r *Request) string
+
+func (f ResolverFunc) Resolve(r *Request) string {
+	return f(r)
+}
+
+// 从 HTTP 请求头中读取
+func FromHeader(name string) Resolver {
+	return ResolverFunc(func(r *Request) string {
+		if r.Header == nil {
+			return ""
+		}
+		return r.Header(name)
+	})
+}
+
+// 从 gRPC metadata 中读取
+func FromMetadata(key string) Resolver {
+	return ResolverFunc(func(r *Request) string {
+		if r.Metadata == nil {
+			return ""
+		}
+		return r.Metadata(key)
+	})
+}
+
+// 从 span 的 baggage 中读取, 要求 tracing 中间件在租户中间件之前
+func FromBaggage() Resolver {
+	return ResolverFunc(func(r *Request) string {
+		if r.Baggage == nil {
+			return ""
+		}
+		return r.Baggage(BaggageKey)
+	})
+}
+
+// 取 Host 中 domain 前面的一段, 例如 domain 为 example.com 时 vip.example.com 解析为 vip;
+// domain 为空时取至少三段的域名的第一段
+func FromSubdomain(domain string) Resolver {
+	domain = strings.Trim(strings.ToLower(domain), ".")
+	return ResolverFunc(func(r *Request) string {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) != nil {
+			return ""
+		}
+
+		if domain != "" {
+			sub := strings.TrimSuffix(host, "."+domain)
+			if sub == host || sub == "" {
+				return ""
+			}
+			return sub[strings.LastIndex(sub, ".")+1:]
+		}
+		labels := strings.Split(host, ".")
+		if len(labels) < 3 {
+			return ""
+		}
+		return labels[0]
+	})
+}
+
+// 取路径前缀后的第一段, 例如 prefix 为 /site/ 时 /site/vip/user/test 解析为 vip
+func FromPathPrefix(prefix string) Resolver {
+	prefix = "/" + strings.Trim(prefix, "/") + "/"
+	return ResolverFunc(func(r *Request) string {
+		if !strings.HasPrefix(r.Path, prefix) {
+			return ""
+		}
+		rest := r.Path[len(prefix):]
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			rest = rest[:i]
+		}
+		return rest
+	})
+}
+
+// 读取 Authorization: Bearer <jwt> 中的 claim;
+// 这里不校验签名, 签名由网关或认证中间件校验, 未经校验的 token 不能作为租户的依据
+func FromJWTClaim(claim string) Resolver {
+	return ResolverFunc(func(r *Request) string {
+		var auth string
+		if r.Header != nil {
+			auth = r.Header("Authorization")
+		}
+		if auth == "" && r.Metadata != nil {
+			auth = r.Metadata("authorization")
+		}
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			return ""
+		}
+
+		parts := strings.Split(strings.TrimSpace(auth[7:]), ".")
+		if len(parts) != 3 {
+			return ""
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+		if err != nil {
+			return ""
+		}
+		var claims map[string]interface{}
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			return ""
+		}
+		switch v := claims[claim].(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return ""
+	})
+}
+
+// 固定的租户
+func Static(siteCode string) Resolver {
+	return ResolverFunc(func(r *Request) string {
+		return siteCode
+	})
+}
+
+// 租户解析配置, 每个服务单独配置
+type Config struct {
+	Resolvers  []string //依次尝试的解析方式, 见 ResolverHeader 等
+	Header     string   //header 和 metadata 的 key, 默认 SiteCode
+	Domain     string   //subdomain 的主域名, 为空时取第一段
+	PathPrefix string   //path 的前缀, 默认 /site/
+	Claim      string   //jwt 的 claim, 默认 siteCode
+	Default    string   //default 的租户, 以及非严格模式下都解析不到时的租户, 默认 001
+
+	Strict  bool     //严格模式: 解析不到或不在 Tenants 中时拒绝请求, 不再使用 Default
+	Tenants []string //已知的租户, 为空时不校验
+}
+
+// 默认配置, 与原来的行为一致: 读取 SiteCode 请求头或 metadata, 其次是 baggage, 都没有时为 001
+func DefaultConfig() *Config {
+	return &Config{Resolvers: []string{ResolverHeader, ResolverMetadata, ResolverBaggage}}
+}
+
+// 用环境变量覆盖配置, 未设置的变量保留原值, prefix 区分服务, 例如 API_TENANT_:
+// RESOLVERS(逗号分隔)、HEADER、DOMAIN、PATH_PREFIX、JWT_CLAIM、DEFAULT、STRICT、KNOWN(逗号分隔)
+func (c *Config) FromEnv(prefix string) (*Config, error) {
+	if e := os.Getenv(prefix + "RESOLVERS"); e != "" {
+		c.Resolvers = strings.Split(e, ",")
+	}
+	if e := os.Getenv(prefix + "HEADER"); e != "" {
+		c.Header = e
+	}
+	if e := os.Getenv(prefix + "DOMAIN"); e != "" {
+		c.Domain = e
+	}
+	if e := os.Getenv(prefix + "PATH_PREFIX"); e != "" {
+		c.PathPrefix = e
+	}
+	if e := os.Getenv(prefix + "JWT_CLAIM"); e != "" {
+		c.Claim = e
+	}
+	if e := os.Getenv(prefix + "DEFAULT"); e != "" {
+		c.Default = e
+	}
+	if e := os.Getenv(prefix + "STRICT"); e != "" {
+		value, err := strconv.ParseBool(e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse env var %sSTRICT=%s", prefix, e)
+		}
+		c.Strict = value
+	}
+	if e := os.Getenv(prefix + "KNOWN"); e != "" {
+		c.Tenants = strings.Split(e, ",")
+	}
+	return c, nil
+}
+
+// 按配置依次尝试的解析器
+type Chain struct {
+	resolvers []Resolver
+	fallback  string
+	strict    bool
+	known     map[string]bool
+}
+
+func NewChain(c *Config) (*Chain, error) {
+	header := c.Header
+	if header == "" {
+		header = Header
+	}
+	pathPrefix := c.PathPrefix
+	if pathPrefix == "" {
+		pathPrefix = "/site/"
+	}
+	claim := c.Claim
+	if claim == "" {
+		claim = "siteCode"
+	}
+	fallback := c.Default
+	if fallback == "" {
+		fallback = Default
+	}
+
+	chain := &Chain{fallback: fallback, strict: c.Strict}
+	for _, name := range c.Resolvers {
+		switch strings.TrimSpace(name) {
+		case ResolverHeader:
+			chain.resolvers = append(chain.resolvers, FromHeader(header))
+		case ResolverMetadata:
+			chain.resolvers = append(chain.resolvers, FromMetadata(header))
+		case ResolverBaggage:
+			chain.resolvers = append(chain.resolvers, FromBaggage())
+		case ResolverSubdomain:
+			chain.resolvers = append(chain.resolvers, FromSubdomain(c.Domain))
+		case ResolverPath:
+			chain.resolvers = append(chain.resolvers, FromPathPrefix(pathPrefix))
+		case ResolverJWT:
+			chain.resolvers = append(chain.resolvers, FromJWTClaim(claim))
+		case ResolverDefault:
+			chain.resolvers = append(chain.resolvers, Static(fallback))
+		default:
+			return nil, fmt.Errorf("unknown tenant resolver %q", name)
+		}
+	}
+	if len(c.Tenants) > 0 {
+		chain.known = make(map[string]bool, len(c.Tenants))
+		for _, t := range c.Tenants {
+			chain.known[strings.TrimSpace(t)] = true
+		}
+	}
+	return chain, nil
+}
+
+// 默认配置的解析器
+func DefaultChain() *Chain {
+	chain, _ := NewChain(DefaultConfig())
+	return chain
+}
+
+// 依次尝试, 返回第一个解析到的租户;
+// 严格模式下解析不到返回 ErrNoTenant, 不在已知租户中返回 ErrUnknownTenant, 否则使用 Default
+func (c *Chain) Resolve(r *Request) (string, error) {
+	siteCode := ""
+	for _, resolver := range c.resolvers {
+		if siteCode = strings.TrimSpace(resolver.Resolve(r)); siteCode != "" {
+			break
+		}
+	}
+
+	if !c.strict {
+		if siteCode == "" {
+			siteCode = c.fallback
+		}
+		return siteCode, nil
+	}
+	if siteCode == "" {
+		return "", ErrNoTenant
+	}
+	if c.known != nil && !c.known[siteCode] {
+		return "", errors.Wrapf(ErrUnknownTenant, "%q", siteCode)
+	}
+	return siteCode, nil
+}
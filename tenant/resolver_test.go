@@ -0,0 +1,173 @@
+package tenant
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func values(m map[string]string) func(key string) string {
+	return func(key string) string {
+		return m[key]
+	}
+}
+
+func bearer(payload string) string {
+	return "Bearer eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+// 按配置的顺序使用第一个解析到的租户
+func TestChainOrder(t *testing.T) {
+	r := &Request{
+		Header:  values(map[string]string{"SiteCode": "header", "Authorization": bearer(`{"siteCode":"jwt"}`)}),
+		Baggage: values(map[string]string{BaggageKey: "baggage"}),
+		Host:    "sub.example.com",
+		Path:    "/site/path/user/test",
+	}
+	cases := []struct {
+		resolvers []string
+		want      string
+	}{
+		{[]string{ResolverHeader, ResolverBaggage, ResolverSubdomain}, "header"},
+		{[]string{ResolverBaggage, ResolverHeader}, "baggage"},
+		{[]string{ResolverSubdomain, ResolverHeader}, "sub"},
+		{[]string{ResolverPath, ResolverHeader}, "path"},
+		{[]string{ResolverJWT, ResolverHeader}, "jwt"},
+		{[]string{ResolverDefault, ResolverHeader}, Default},
+		//metadata 没有值时交给下一个
+		{[]string{ResolverMetadata, ResolverJWT}, "jwt"},
+	}
+	for _, c := range cases {
+		chain, err := NewChain(&Config{Resolvers: c.resolvers, Domain: "example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := chain.Resolve(r); err != nil || got != c.want {
+			t.Errorf("%v: Resolve = %q, %v, want %q", c.resolvers, got, err, c.want)
+		}
+	}
+
+	if _, err := NewChain(&Config{Resolvers: []string{"cookie"}}); err == nil {
+		t.Error("unknown resolver should fail")
+	}
+}
+
+func TestChainStrict(t *testing.T) {
+	cases := []struct {
+		name    string
+		strict  bool
+		header  string
+		want    string
+		wantErr error
+	}{
+		{"lenient no tenant", false, "", "009", nil},
+		{"lenient known", false, "007", "007", nil},
+		{"lenient unknown", false, "999", "999", nil},
+		{"strict no tenant", true, "", "", ErrNoTenant},
+		{"strict blank tenant", true, "  ", "", ErrNoTenant},
+		{"strict known", true, " 007 ", "007", nil},
+		{"strict unknown", true, "999", "", ErrUnknownTenant},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chain, err := NewChain(&Config{
+				Resolvers: []string{ResolverHeader},
+				Default:   "009",
+				Strict:    c.strict,
+				Tenants:   []string{"007", " 008"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := chain.Resolve(&Request{Header: values(map[string]string{Header: c.header})})
+			if errors.Cause(err) != c.wantErr || got != c.want {
+				t.Errorf("Resolve = %q, %v, want %q, %v", got, err, c.want, c.wantErr)
+			}
+		})
+	}
+
+	//没有配置已知租户时不校验
+	chain, _ := NewChain(&Config{Resolvers: []string{ResolverHeader}, Strict: true})
+	if got, err := chain.Resolve(&Request{Header: values(map[string]string{Header: "999"})}); err != nil || got != "999" {
+		t.Errorf("Resolve = %q, %v", got, err)
+	}
+}
+
+func TestFromSubdomain(t *testing.T) {
+	cases := []struct {
+		domain, host, want string
+	}{
+		{"example.com", "vip.example.com", "vip"},
+		{"example.com", "VIP.Example.com:8080", "vip"},
+		{"example.com", "a.vip.example.com", "vip"},
+		{".example.com.", "vip.example.com", "vip"},
+		{"example.com", "example.com", ""},
+		{"example.com", "vip.example.org", ""},
+		{"example.com", "vipexample.com", ""},
+		{"", "vip.example.com:8080", "vip"},
+		{"", "example.com", ""},
+		{"", "localhost:8080", ""},
+		{"", "10.0.0.1", ""},
+		{"", "10.0.0.1:8080", ""},
+		{"", "[::1]:8080", ""},
+		{"", "::1", ""},
+		{"example.com", "", ""},
+	}
+	for _, c := range cases {
+		if got := FromSubdomain(c.domain).Resolve(&Request{Host: c.host}); got != c.want {
+			t.Errorf("FromSubdomain(%q) host %q = %q, want %q", c.domain, c.host, got, c.want)
+		}
+	}
+}
+
+func TestFromPathPrefix(t *testing.T) {
+	cases := []struct {
+		prefix, path, want string
+	}{
+		{"/site/", "/site/vip/user/test", "vip"},
+		{"site", "/site/vip", "vip"},
+		{"/site/", "/site/", ""},
+		{"/site/", "/sites/vip/user", ""},
+		{"/site/", "/user/test", ""},
+	}
+	for _, c := range cases {
+		if got := FromPathPrefix(c.prefix).Resolve(&Request{Path: c.path}); got != c.want {
+			t.Errorf("FromPathPrefix(%q) path %q = %q, want %q", c.prefix, c.path, got, c.want)
+		}
+	}
+}
+
+func TestFromJWTClaim(t *testing.T) {
+	cases := []struct {
+		name string
+		auth string
+		want string
+	}{
+		{"string claim", bearer(`{"siteCode":"007"}`), "007"},
+		{"lower case scheme", "bearer" + bearer(`{"siteCode":"007"}`)[6:], "007"},
+		{"padded payload", "Bearer x." + base64.URLEncoding.EncodeToString([]byte(`{"siteCode":"07"}`)) + ".sig", "07"},
+		{"number claim", bearer(`{"siteCode":7}`), "7"},
+		{"missing claim", bearer(`{"sub":"user"}`), ""},
+		{"bool claim", bearer(`{"siteCode":true}`), ""},
+		{"object claim", bearer(`{"siteCode":{"id":"007"}}`), ""},
+		{"payload not json", bearer(`siteCode=007`), ""},
+		{"payload not base64", "Bearer x.!!!.sig", ""},
+		{"two parts", "Bearer x." + base64.RawURLEncoding.EncodeToString([]byte(`{"siteCode":"007"}`)), ""},
+		{"basic auth", "Basic dXNlcjpwYXNz", ""},
+		{"no header", "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Request{Header: values(map[string]string{"Authorization": c.auth})}
+			if got := FromJWTClaim("siteCode").Resolve(r); got != c.want {
+				t.Errorf("header %q = %q, want %q", c.auth, got, c.want)
+			}
+			//gRPC 从 metadata 中读取
+			r = &Request{Metadata: values(map[string]string{"authorization": c.auth})}
+			if got := FromJWTClaim("siteCode").Resolve(r); got != c.want {
+				t.Errorf("metadata %q = %q, want %q", c.auth, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// 租户(SiteCode)在请求头、gRPC metadata 和上下文中的约定
+const (
+	Header  = "SiteCode" //HTTP 请求头和 gRPC metadata 的 key
+	Default = "001"      //请求没有带租户时使用
+)
+
+// 租户在 trace 中的约定: baggage 随 span context 传到所有下游, tag 用于在 Jaeger 中按租户过滤
+const (
+	BaggageKey = "sitecode" //HTTP 头和 gRPC metadata 会把 baggage 的 key 转为小写, 这里直接用小写
+	TagKey     = "siteCode"
+)
+
+// 不导出的 key 类型, 其他包无法用字符串 key 覆盖或读取
+type contextKey struct{}
+
+// 把租户放入上下文, 上下文中有 span 时同时设置为 baggage 和 tag
+func WithTenant(ctx context.Context, siteCode string) context.Context {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetBaggageItem(BaggageKey, siteCode)
+		span.SetTag(TagKey, siteCode)
+	}
+	return context.WithValue(ctx, contextKey{}, siteCode)
+}
+
+// 上下文中的租户, 没有设置时读取 span 的 baggage, 都为空时 ok 为 false
+func TenantFrom(ctx context.Context) (siteCode string, ok bool) {
+	if ctx == nil {
+		return "", false
+	}
+	if siteCode, ok = ctx.Value(contextKey{}).(string); ok && siteCode != "" {
+		return siteCode, true
+	}
+	siteCode = Baggage(ctx)(BaggageKey)
+	return siteCode, siteCode != ""
+}
+
+// 按 key 读取上下文中 span 的 baggage, 没有 span 时返回空字符串;
+// OpenTelemetry bridge 把 baggage 的 key 转为 http.CanonicalHeaderKey 的形式(Sitecode), 这里不区分大小写
+func Baggage(ctx context.Context) func(key string) string {
+	span := opentracing.SpanFromContext(ctx)
+	return func(key string) string {
+		if span == nil {
+			return ""
+		}
+		if value := span.BaggageItem(key); value != "" {
+			return value
+		}
+		var value string
+		span.Context().ForeachBaggageItem(func(k, v string) bool {
+			if strings.EqualFold(k, key) {
+				value = v
+				return false
+			}
+			return true
+		})
+		return value
+	}
+}